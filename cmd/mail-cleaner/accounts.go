@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mail-cleaner/internal/config"
+	"mail-cleaner/internal/imap"
+	"mail-cleaner/internal/rules"
+	"mail-cleaner/internal/rules/rule"
+	"mail-cleaner/internal/state"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// accountOptions are the command line flags applied to every account of an
+// accounts file.
+type accountOptions struct {
+	dryRun     bool
+	noSearch   bool
+	fullRescan bool
+	moveTo     string
+	folders    string
+	topSenders int
+}
+
+// runAccounts processes the accounts of the file at path, parallel of them at
+// a time, then prints their reports in file order and a combined summary.
+func runAccounts(path string, parallel int, opts accountOptions) {
+	file, err := config.LoadAccounts(path)
+	if err != nil {
+		fmt.Printf("Failed to load accounts: %v\n", err)
+		os.Exit(1)
+	}
+	if parallel > 0 {
+		file.Parallel = parallel
+	}
+	fmt.Printf("Processing %d accounts, %d at a time\n", len(file.Accounts), file.Parallel)
+
+	results := make([]imap.AccountResult, len(file.Accounts))
+	stores := &stateStores{stores: make(map[string]*state.Store)}
+	var stdout sync.Mutex
+	slots := make(chan struct{}, file.Parallel)
+	var wg sync.WaitGroup
+	for i, account := range file.Accounts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			// lines of accounts processed at the same time are told
+			// apart by the account name
+			var out io.Writer = os.Stdout
+			if file.Parallel > 1 {
+				prefixed := &prefixWriter{prefix: "[" + account.Name + "] ", w: os.Stdout, mu: &stdout}
+				defer prefixed.Flush()
+				out = prefixed
+			}
+
+			report, err := runAccount(account, opts, stores, out)
+			results[i] = imap.AccountResult{Name: account.Name, Report: report, Err: err}
+		}()
+	}
+	wg.Wait()
+
+	// reports are printed once all accounts are done, parallel logs would
+	// mix them up
+	for _, result := range results {
+		if result.Report == nil {
+			continue
+		}
+		fmt.Printf("\n=== Account %s ===\n", result.Name)
+		if result.Report.DryRun {
+			result.Report.Print(os.Stdout)
+		}
+		result.Report.PrintSummary(os.Stdout)
+		if opts.topSenders > 0 {
+			result.Report.PrintTopSenders(os.Stdout, opts.topSenders)
+		}
+	}
+	imap.PrintAccountsSummary(os.Stdout, results)
+}
+
+// runAccount cleans one account, printing its progress to out.
+func runAccount(account config.Account, opts accountOptions, stores *stateStores, out io.Writer) (*imap.Report, error) {
+	fmt.Fprintf(out, "\nLoading account %s (service %s)\n", account.Name, account.Service)
+
+	cfg, err := account.Config()
+	if err != nil {
+		return nil, err
+	}
+	cfg.DryRun = cfg.DryRun || opts.dryRun
+	cfg.NoSearch = cfg.NoSearch || opts.noSearch
+	cfg.FullRescan = cfg.FullRescan || opts.fullRescan
+	if opts.moveTo != "" {
+		cfg.MoveTo = opts.moveTo
+	}
+
+	rules_file, err := rule.LoadFile(account.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rules from file: %v", err)
+	}
+	defer closeRules(rules_file.Rules)
+	if len(rules_file.Rules) == 0 {
+		fmt.Fprintln(out, "No rules found in the rules file.")
+	}
+
+	// -folders wins over the account, which wins over its rules file
+	if opts.folders != "" {
+		cfg.Folders = config.SplitList(opts.folders)
+	} else if len(account.Folders) == 0 && len(rules_file.Folders) > 0 {
+		cfg.Folders = rules_file.Folders
+	}
+
+	imapClient := imap.NewClient(cfg)
+	imapClient.SetOutput(out)
+	// a dry run keeps its progress in memory, it must not end up in the
+	// file through a store shared with other accounts
+	if cfg.StateFile != "" && !cfg.DryRun {
+		store, err := stores.load(cfg.StateFile)
+		if err != nil {
+			return nil, err
+		}
+		imapClient.SetState(store)
+	}
+	if err := imapClient.Connect(); err != nil {
+		return nil, err
+	}
+	defer imapClient.Disconnect()
+
+	rulesSet := rules.NewRules(rules_file.Rules)
+	rulesSet.SetOutput(out)
+	return imapClient.CleanEmails(rulesSet)
+}
+
+// stateStores shares one state store per state file between the accounts of a
+// run. Accounts processed at the same time would otherwise each load the file
+// and overwrite the progress the others saved.
+type stateStores struct {
+	mu     sync.Mutex
+	stores map[string]*state.Store
+}
+
+// load returns the store of the state file at path, loading it on first use.
+func (s *stateStores) load(path string) (*state.Store, error) {
+	// the same file may be named relative to different directories, e.g. in
+	// the accounts file and in STATE_FILE
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if store, ok := s.stores[abs]; ok {
+		return store, nil
+	}
+	store, err := state.Load(abs)
+	if err != nil {
+		return nil, err
+	}
+	s.stores[abs] = store
+	return store, nil
+}
+
+// prefixWriter writes complete lines to w, each starting with prefix. mu is
+// shared by all writers of w, so lines of different writers don't mix.
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+	mu     *sync.Mutex
+	// line holds the start of a line until its end is written.
+	line []byte
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.line = append(p.line, data...)
+	for {
+		end := bytes.IndexByte(p.line, '\n')
+		if end < 0 {
+			return len(data), nil
+		}
+		if err := p.writeLine(p.line[:end+1]); err != nil {
+			return len(data), err
+		}
+		p.line = p.line[end+1:]
+	}
+}
+
+// Flush writes a last line that has no line break.
+func (p *prefixWriter) Flush() error {
+	if len(p.line) == 0 {
+		return nil
+	}
+	line := append(p.line, '\n')
+	p.line = nil
+	return p.writeLine(line)
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// blank lines only separate sections, they stay blank
+	if len(line) == 1 {
+		_, err := p.w.Write(line)
+		return err
+	}
+	_, err := fmt.Fprintf(p.w, "%s%s", p.prefix, line)
+	return err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"mail-cleaner/internal/state"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		flush  bool
+		want   string
+	}{
+		{
+			name:   "complete lines",
+			writes: []string{"one\ntwo\n"},
+			want:   "[a] one\n[a] two\n",
+		},
+		{
+			name:   "partial line waits for its end",
+			writes: []string{"Checking ", "INBOX", "... done\n", "next"},
+			want:   "[a] Checking INBOX... done\n",
+		},
+		{
+			name:   "flush ends the last line",
+			writes: []string{"one\n", "two"},
+			flush:  true,
+			want:   "[a] one\n[a] two\n",
+		},
+		{
+			name:   "flush without a partial line",
+			writes: []string{"one\n"},
+			flush:  true,
+			want:   "[a] one\n",
+		},
+		{
+			name:   "blank lines stay blank",
+			writes: []string{"\nLoading\n\n"},
+			want:   "\n[a] Loading\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			p := &prefixWriter{prefix: "[a] ", w: &out, mu: &sync.Mutex{}}
+			for _, data := range tt.writes {
+				if n, err := p.Write([]byte(data)); err != nil || n != len(data) {
+					t.Fatalf("Write(%q) = %d, %v", data, n, err)
+				}
+			}
+			if tt.flush {
+				if err := p.Flush(); err != nil {
+					t.Fatalf("Flush() error = %v", err)
+				}
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefixWriter_SharedOutput(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	a := &prefixWriter{prefix: "[a] ", w: &out, mu: &mu}
+	b := &prefixWriter{prefix: "[b] ", w: &out, mu: &mu}
+
+	a.Write([]byte("Checking "))
+	b.Write([]byte("Checking INBOX\n"))
+	a.Write([]byte("Archive\n"))
+
+	want := "[b] Checking INBOX\n[a] Checking Archive\n"
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestStateStores_Load(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	stores := &stateStores{stores: make(map[string]*state.Store)}
+
+	relative, err := stores.load("state.json")
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	absolute, err := stores.load(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if relative != absolute {
+		t.Errorf("load() returned different stores for the same file")
+	}
+
+	other, err := stores.load("other.json")
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if other == relative {
+		t.Errorf("load() returned the same store for different files")
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"io"
 	"mail-cleaner/internal/config"
@@ -8,37 +10,86 @@ import (
 	"mail-cleaner/internal/rules"
 	"mail-cleaner/internal/rules/rule"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	dryRun := flag.Bool("dry-run", false, "report matching emails without deleting them")
+	moveTo := flag.String("move-to", "", "move matching emails to this folder instead of deleting them")
+	topSenders := flag.Int("top-senders", 0, "list the N senders taking the most mailbox space")
+	noSearch := flag.Bool("no-search", false, "fetch every email instead of searching the server for candidates first")
+	stateFile := flag.String("state", "", "remember processed emails in this file and only process new ones on the next run")
+	fullRescan := flag.Bool("full-rescan", false, "process every email, even those the state file says were processed")
+	folders := flag.String("folders", "", "comma separated folders or globs to process, \"all\" for every folder (default INBOX)")
+	watch := flag.Bool("watch", false, "after the first pass, keep running and clean new emails in INBOX as they arrive")
+	accounts := flag.String("accounts", "", "process every account listed in this file instead of one service")
+	parallel := flag.Int("parallel", 0, "with -accounts, how many accounts to process at the same time (default from the file, or 1)")
+	flag.Usage = func() {
+		fmt.Println("Usage: mail-cleaner [-dry-run] [-move-to <folder>] [-folders <list>] [-no-search] [-state <file> [-full-rescan]] [-top-senders N] [-watch] <service_name> <rule_set_file>")
+		fmt.Println("       mail-cleaner -accounts <file> [-parallel N] [-dry-run] [-move-to <folder>] [-folders <list>] [-no-search] [-full-rescan] [-top-senders N]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *accounts != "" {
+		if *watch || *stateFile != "" {
+			fmt.Println("-watch and -state can't be used with -accounts, set state_file per account instead")
+			os.Exit(1)
+		}
+		runAccounts(*accounts, *parallel, accountOptions{
+			dryRun:     *dryRun,
+			noSearch:   *noSearch || *topSenders > 0,
+			fullRescan: *fullRescan,
+			moveTo:     *moveTo,
+			folders:    *folders,
+			topSenders: *topSenders,
+		})
+		return
+	}
+
 	//get service name from input arguments
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: mail-cleaner <service_name> <rule_set_file>")
+	if flag.NArg() < 2 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	service_name := os.Args[1]
+	service_name := flag.Arg(0)
 	fmt.Printf("Loading config for service: %s\n", service_name)
+
 	cfg := config.LoadConfig(service_name)
+	cfg.DryRun = *dryRun
+	// top senders are counted over every email, not only the candidates
+	cfg.NoSearch = *noSearch || *topSenders > 0
+	if *moveTo != "" {
+		cfg.MoveTo = *moveTo
+	}
+	if *stateFile != "" {
+		cfg.StateFile = *stateFile
+	}
+	cfg.FullRescan = *fullRescan
 	fmt.Println(cfg)
 
-	rule_set_file := os.Args[2]
+	rule_set_file := flag.Arg(1)
 
-	rules_list, err := rule.CreateFromFile(rule_set_file)
+	rules_file, err := rule.LoadFile(rule_set_file)
 	if err != nil {
 		fmt.Printf("Failed to create rules from file: %v\n", err)
 		os.Exit(1)
 	}
+	rules_list := rules_file.Rules
+	if len(rules_list) == 0 {
+		fmt.Println("No rules found in the rules file.")
+	}
 
-	defer func() {
-		for _, r := range rules_list {
-			if closer, ok := r.(io.Closer); ok {
-				if err := closer.Close(); err != nil {
-					fmt.Printf("Error closing rule: %v\n", err)
-				}
-			}
-		}
-	}()
+	// -folders wins over the rules file, which wins over FOLDERS in .env
+	if *folders != "" {
+		cfg.Folders = config.SplitList(*folders)
+	} else if len(rules_file.Folders) > 0 {
+		cfg.Folders = rules_file.Folders
+	}
+
+	defer closeRules(rules_list)
 
 	imapClient := imap.NewClient(cfg)
 	if err := imapClient.Connect(); err != nil {
@@ -47,6 +98,40 @@ func main() {
 	}
 	defer imapClient.Disconnect()
 
-	imapClient.CleanEmails(rules.NewRules(rules_list))
+	printReport := func(report *imap.Report) {
+		if cfg.DryRun {
+			report.Print(os.Stdout)
+		}
+		report.PrintSummary(os.Stdout)
+		if *topSenders > 0 {
+			report.PrintTopSenders(os.Stdout, *topSenders)
+		}
+	}
+
+	if *watch {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := imapClient.Watch(ctx, rules.NewRules(rules_list), printReport); err != nil {
+			fmt.Printf("Error watching emails: %v\n", err)
+		}
+		return
+	}
+
+	report, err := imapClient.CleanEmails(rules.NewRules(rules_list))
+	if err != nil {
+		fmt.Printf("Error cleaning emails: %v\n", err)
+	}
+	if report != nil {
+		printReport(report)
+	}
+}
 
+func closeRules(rules_list []rules.Rule) {
+	for _, r := range rules_list {
+		if closer, ok := r.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				fmt.Printf("Error closing rule: %v\n", err)
+			}
+		}
+	}
 }
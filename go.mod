@@ -3,8 +3,14 @@ module mail-cleaner
 go 1.25.4
 
 require (
-	github.com/emersion/go-imap v1.2.1 // indirect
-	github.com/emersion/go-sasl v0.0.0-20200509203442-7bfe0ed36a21 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	golang.org/x/text v0.3.7 // indirect
+	github.com/emersion/go-imap v1.2.1
+	github.com/emersion/go-sasl v0.0.0-20200509203442-7bfe0ed36a21
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/net v0.47.0
+	golang.org/x/text v0.31.0
+)
+
+require (
+	github.com/emersion/go-message v0.15.0 // indirect
+	github.com/emersion/go-textwrapper v0.0.0-20200911093747-65d896831594 // indirect
 )
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Account is one mailbox of an accounts file. Its connection settings and
+// credentials come from .env.<service> next to the accounts file, the other
+// fields override them.
+type Account struct {
+	// Name labels the account in the output, Service by default.
+	Name    string `json:"name"`
+	Service string `json:"service"`
+	// Rules is the rules file of the account.
+	Rules      string   `json:"rules"`
+	Folders    []string `json:"folders"`
+	DryRun     bool     `json:"dry_run"`
+	MoveTo     string   `json:"move_to"`
+	NoSearch   bool     `json:"no_search"`
+	StateFile  string   `json:"state_file"`
+	FullRescan bool     `json:"full_rescan"`
+
+	// dir is the directory of the accounts file, the current one when empty.
+	dir string
+}
+
+// AccountsFile lists the accounts processed in one run.
+type AccountsFile struct {
+	// Parallel is how many accounts are processed at the same time, one by
+	// default.
+	Parallel int       `json:"parallel"`
+	Accounts []Account `json:"accounts"`
+}
+
+// LoadAccounts reads an accounts file. The .env.<service> files and relative
+// rules and state file paths are resolved against the directory of the file.
+func LoadAccounts(path string) (*AccountsFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file AccountsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse accounts file: %v", err)
+	}
+	if len(file.Accounts) == 0 {
+		return nil, fmt.Errorf("no accounts in %s", path)
+	}
+	if file.Parallel < 1 {
+		file.Parallel = 1
+	}
+
+	dir := filepath.Dir(path)
+	names := make(map[string]bool)
+	for i := range file.Accounts {
+		account := &file.Accounts[i]
+		if account.Service == "" || account.Rules == "" {
+			return nil, fmt.Errorf("account %d needs a service and a rules file", i+1)
+		}
+		if account.Name == "" {
+			account.Name = account.Service
+		}
+		if names[account.Name] {
+			return nil, fmt.Errorf("duplicate account name %q", account.Name)
+		}
+		names[account.Name] = true
+
+		account.dir = dir
+		account.Rules = resolve(dir, account.Rules)
+		if account.StateFile != "" {
+			account.StateFile = resolve(dir, account.StateFile)
+		}
+	}
+	return &file, nil
+}
+
+func resolve(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Config loads the settings of the account's service and applies the
+// account's overrides. Only the .env.<service> file is read: variables set in
+// the environment would apply to every account of the file.
+func (a *Account) Config() (*Config, error) {
+	cfg, err := LoadFile(a.Service, filepath.Join(a.dir, ".env."+a.Service))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(a.Folders) > 0 {
+		cfg.Folders = a.Folders
+	}
+	if a.MoveTo != "" {
+		cfg.MoveTo = a.MoveTo
+	}
+	if a.StateFile != "" {
+		cfg.StateFile = a.StateFile
+	}
+	cfg.DryRun = a.DryRun
+	cfg.NoSearch = a.NoSearch
+	cfg.FullRescan = a.FullRescan
+	return cfg, nil
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestLoadAccounts(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "accounts.json")
+	writeFile(t, path, `{
+		"parallel": 2,
+		"accounts": [
+			{"service": "work", "rules": "work.json", "state_file": "work.state.json"},
+			{"name": "home", "service": "personal", "rules": "/etc/mail-cleaner/home.json", "dry_run": true}
+		]
+	}`)
+
+	file, err := LoadAccounts(path)
+	if err != nil {
+		t.Fatalf("LoadAccounts() error = %v", err)
+	}
+	if file.Parallel != 2 || len(file.Accounts) != 2 {
+		t.Fatalf("LoadAccounts() = %+v", file)
+	}
+	work := file.Accounts[0]
+	if work.Name != "work" || work.Rules != filepath.Join(dir, "work.json") || work.StateFile != filepath.Join(dir, "work.state.json") {
+		t.Errorf("work account = %+v", work)
+	}
+	home := file.Accounts[1]
+	if home.Name != "home" || home.Rules != "/etc/mail-cleaner/home.json" || !home.DryRun {
+		t.Errorf("home account = %+v", home)
+	}
+
+	invalid := []string{
+		`{"accounts": []}`,
+		`{"accounts": [{"service": "work"}]}`,
+		`{"accounts": [{"service": "work", "rules": "a.json"}, {"service": "work", "rules": "b.json"}]}`,
+		`[{"service": "work", "rules": "a.json"}]`,
+	}
+	for _, content := range invalid {
+		writeFile(t, path, content)
+		if _, err := LoadAccounts(path); err == nil {
+			t.Errorf("LoadAccounts(%s) succeeded, want an error", content)
+		}
+	}
+}
+
+func TestAccount_Config(t *testing.T) {
+	t.Chdir(t.TempDir())
+	// the environment would apply to every account, only the files count
+	t.Setenv("EMAIL", "me@env.example")
+	t.Setenv("IMAP_PORT", "1143")
+	writeFile(t, ".env.work", "IMAP_SERVER=imap.work.example\nIMAP_PORT=993\nEMAIL=me@work.example\nFOLDERS=INBOX,Archive\n")
+	writeFile(t, ".env.personal", "IMAP_SERVER=imap.home.example\nIMAP_PORT=143\nEMAIL=me@home.example\n")
+
+	work := Account{Service: "work", Rules: "work.json", Folders: []string{"Newsletters"}, MoveTo: "Trash"}
+	cfg, err := work.Config()
+	if err != nil {
+		t.Fatalf("Config() error = %v", err)
+	}
+	if cfg.IMAPServer != "imap.work.example" || cfg.Email != "me@work.example" || cfg.IMAPPort != 993 || cfg.MoveTo != "Trash" || len(cfg.Folders) != 1 || cfg.Folders[0] != "Newsletters" {
+		t.Errorf("work config = %v", cfg)
+	}
+
+	// the first service must not leak into the second one
+	personal := Account{Service: "personal", Rules: "home.json"}
+	cfg, err = personal.Config()
+	if err != nil {
+		t.Fatalf("Config() error = %v", err)
+	}
+	if cfg.IMAPServer != "imap.home.example" || cfg.IMAPPort != 143 || len(cfg.Folders) != 0 {
+		t.Errorf("personal config = %v", cfg)
+	}
+
+	missing := Account{Service: "missing", Rules: "x.json"}
+	if _, err := missing.Config(); err == nil {
+		t.Errorf("Config() without .env.missing succeeded")
+	}
+}
+
+func TestLoadAccounts_EnvFileNextToAccounts(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "accounts.json")
+	writeFile(t, path, `{"accounts": [{"service": "work", "rules": "work.json"}]}`)
+	writeFile(t, filepath.Join(dir, ".env.work"), "IMAP_SERVER=imap.work.example\nIMAP_PORT=993\n")
+	// a file of the same service in the current directory is not used
+	t.Chdir(t.TempDir())
+	writeFile(t, ".env.work", "IMAP_SERVER=imap.other.example\nIMAP_PORT=143\n")
+
+	file, err := LoadAccounts(path)
+	if err != nil {
+		t.Fatalf("LoadAccounts() error = %v", err)
+	}
+	cfg, err := file.Accounts[0].Config()
+	if err != nil {
+		t.Fatalf("Config() error = %v", err)
+	}
+	if cfg.IMAPServer != "imap.work.example" || cfg.IMAPPort != 993 {
+		t.Errorf("Config() = %v, want the settings of %s", cfg, filepath.Join(dir, ".env.work"))
+	}
+}
@@ -1,9 +1,12 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -13,26 +16,146 @@ type Config struct {
 	IMAPPort   int
 	Email      string
 	Password   string
+
+	// AuthMethod is how to log in: "password" (the default), "xoauth2" or
+	// "oauthbearer". The OAuth methods get their access token from
+	// OAuthTokenCommand, a refresh token exchange at OAuthTokenURL or
+	// OAuthToken, in that order.
+	AuthMethod string
+	// OAuthToken is a static access token.
+	OAuthToken string
+	// OAuthTokenURL is the token endpoint the refresh token is exchanged at,
+	// with the client ID and secret of the registered application.
+	OAuthTokenURL     string
+	OAuthClientID     string
+	OAuthClientSecret string
+	OAuthRefreshToken string
+	// OAuthTokenCommand is a shell command printing an access token.
+	OAuthTokenCommand string
+
+	// Security protects the connection: "tls" (the default), "starttls" or
+	// "none", which is only allowed for servers on a loopback address.
+	Security string
+	// TLSCAFile is a PEM bundle of CAs trusted instead of the system ones.
+	TLSCAFile string
+	// TLSCertFile and TLSKeyFile are a PEM client certificate and its key.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSServerName overrides the name the server certificate is checked
+	// against, IMAPServer by default.
+	TLSServerName string
+	// TLSMinVersion is the oldest TLS version accepted, like "1.3". Go's
+	// default, TLS 1.2, is used when empty.
+	TLSMinVersion string
+
+	// DryRun evaluates rules and reports matches without changing the mailbox.
+	DryRun bool
+	// MoveTo is the folder matched emails are moved to instead of being
+	// deleted permanently. Rules with their own "move_to" override it.
+	MoveTo string
+	// Folders are the folder names or globs to process, "all" for every
+	// folder. Only INBOX is processed when empty.
+	Folders []string
+	// NoSearch fetches every email instead of only those found by a server
+	// side SEARCH built from the rules.
+	NoSearch bool
+	// StateFile remembers the last processed UID of every folder, so later
+	// runs only process new emails. Runs are not incremental when empty.
+	StateFile string
+	// FullRescan processes every email even when StateFile says it was
+	// processed before.
+	FullRescan bool
 }
 
+// String prints the config with its secrets masked, for logs.
+func (c *Config) String() string {
+	masked := *c
+	for _, secret := range []*string{&masked.Password, &masked.OAuthToken, &masked.OAuthClientSecret, &masked.OAuthRefreshToken} {
+		if *secret != "" {
+			*secret = "***"
+		}
+	}
+	// plain has no String method, so Sprintf doesn't call this one again
+	type plain Config
+	return fmt.Sprintf("%+v", plain(masked))
+}
+
+// LoadConfig loads the settings of service, see Load, and panics when they
+// are invalid.
 func LoadConfig(service_name string) *Config {
-	// load from env
-	godotenv.Load(".env." + service_name)
-	server := os.Getenv("IMAP_SERVER")
-	portStr := os.Getenv("IMAP_PORT")
-	port, err := strconv.Atoi(portStr)
+	cfg, err := Load(service_name)
+	if err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+	return cfg
+}
+
+// Load reads the settings of service from .env.<service>. Variables set in
+// the environment win over the file. The file is not loaded into the
+// environment, so the settings of several services can be loaded side by
+// side.
+func Load(service string) (*Config, error) {
+	values, err := godotenv.Read(".env." + service)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read .env.%s: %v", service, err)
+	}
+	return parse(service, func(key string) string {
+		if value, ok := os.LookupEnv(key); ok {
+			return value
+		}
+		return values[key]
+	})
+}
+
+// LoadFile reads the settings of service from the file at path only. Unlike
+// Load, the environment is ignored and the file must exist.
+func LoadFile(service, path string) (*Config, error) {
+	values, err := godotenv.Read(path)
 	if err != nil {
-		fmt.Println("Invalid IMAP_PORT")
-		panic("Invalid IMAP_PORT")
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
 	}
+	return parse(service, func(key string) string { return values[key] })
+}
 
-	email := os.Getenv("EMAIL")
-	password := os.Getenv("PASSWORD")
+// parse builds the config of service from the variables env looks up.
+func parse(service string, env func(key string) string) (*Config, error) {
+	port, err := strconv.Atoi(env("IMAP_PORT"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid IMAP_PORT for %s", service)
+	}
 
 	return &Config{
-		IMAPServer: server,
-		IMAPPort:   port,
-		Email:      email,
-		Password:   password,
+		IMAPServer:        env("IMAP_SERVER"),
+		IMAPPort:          port,
+		Email:             env("EMAIL"),
+		Password:          env("PASSWORD"),
+		AuthMethod:        env("IMAP_AUTH"),
+		OAuthToken:        env("OAUTH_TOKEN"),
+		OAuthTokenURL:     env("OAUTH_TOKEN_URL"),
+		OAuthClientID:     env("OAUTH_CLIENT_ID"),
+		OAuthClientSecret: env("OAUTH_CLIENT_SECRET"),
+		OAuthRefreshToken: env("OAUTH_REFRESH_TOKEN"),
+		OAuthTokenCommand: env("OAUTH_TOKEN_COMMAND"),
+		Security:          env("IMAP_SECURITY"),
+		TLSCAFile:         env("TLS_CA_FILE"),
+		TLSCertFile:       env("TLS_CERT_FILE"),
+		TLSKeyFile:        env("TLS_KEY_FILE"),
+		TLSServerName:     env("TLS_SERVER_NAME"),
+		TLSMinVersion:     env("TLS_MIN_VERSION"),
+		MoveTo:            env("MOVE_TO"),
+		Folders:           SplitList(env("FOLDERS")),
+		StateFile:         env("STATE_FILE"),
+	}, nil
+}
+
+// SplitList splits a comma separated list, dropping empty items.
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
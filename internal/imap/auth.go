@@ -0,0 +1,96 @@
+package imap
+
+import (
+	"context"
+	"fmt"
+	"mail-cleaner/internal/config"
+	"mail-cleaner/internal/oauth"
+	"strings"
+
+	"github.com/emersion/go-sasl"
+)
+
+// Authentication methods, see config.Config.AuthMethod.
+const (
+	AuthPassword    = "password"
+	AuthXOAuth2     = "xoauth2"
+	AuthOAuthBearer = "oauthbearer"
+)
+
+// tokenSource picks the access token source configured in cfg.
+func tokenSource(cfg *config.Config) (oauth.TokenSource, error) {
+	switch {
+	case cfg.OAuthTokenCommand != "":
+		return oauth.NewCommandSource(cfg.OAuthTokenCommand), nil
+	case cfg.OAuthTokenURL != "":
+		if cfg.OAuthRefreshToken == "" {
+			return nil, fmt.Errorf("OAuth token URL set without a refresh token")
+		}
+		return oauth.NewRefreshSource(cfg.OAuthTokenURL, cfg.OAuthClientID, cfg.OAuthClientSecret, cfg.OAuthRefreshToken), nil
+	case cfg.OAuthToken != "":
+		return oauth.StaticToken(cfg.OAuthToken), nil
+	default:
+		return nil, fmt.Errorf("OAuth needs a token, a token URL with a refresh token, or a token command")
+	}
+}
+
+// login authenticates with the method set in the config.
+func (c *Client) login() error {
+	method := strings.ToLower(c.config.AuthMethod)
+	if method == "" || method == AuthPassword {
+		return c.client.Login(c.config.Email, c.config.Password)
+	}
+	if method != AuthXOAuth2 && method != AuthOAuthBearer {
+		return fmt.Errorf("unknown auth method %q, use %s, %s or %s", c.config.AuthMethod, AuthPassword, AuthXOAuth2, AuthOAuthBearer)
+	}
+
+	// kept across reconnects, so a refreshed token is reused
+	if c.tokens == nil {
+		tokens, err := tokenSource(c.config)
+		if err != nil {
+			return err
+		}
+		c.tokens = tokens
+	}
+	token, err := c.tokens.Token(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %v", err)
+	}
+
+	if method == AuthOAuthBearer {
+		return c.client.Authenticate(sasl.NewOAuthBearerClient(&sasl.OAuthBearerOptions{
+			Username: c.config.Email,
+			Token:    token,
+		}))
+	}
+
+	auth := &xoauth2Client{username: c.config.Email, token: token}
+	if err := c.client.Authenticate(auth); err != nil {
+		if auth.failure != "" {
+			return fmt.Errorf("%v: %s", err, auth.failure)
+		}
+		return err
+	}
+	return nil
+}
+
+// xoauth2Client implements the XOAUTH2 SASL mechanism used by Gmail and
+// Outlook. go-sasl only has OAUTHBEARER, its standard successor.
+type xoauth2Client struct {
+	username string
+	token    string
+	// failure is the error details the server sent, a JSON object.
+	failure string
+}
+
+func (a *xoauth2Client) Start() (string, []byte, error) {
+	ir := "user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"
+	return "XOAUTH2", []byte(ir), nil
+}
+
+// Next answers the only challenge XOAUTH2 has, the error details, with the
+// empty response servers expect before they fail the command.
+func (a *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	a.failure = string(challenge)
+	return []byte{}, nil
+}
@@ -0,0 +1,120 @@
+package imap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"mail-cleaner/internal/config"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-sasl"
+)
+
+const testAccessToken = "access-token"
+
+// xoauth2Server accepts testAccessToken for username, and otherwise sends
+// the error details and fails once the client answers them.
+type xoauth2Server struct {
+	conn   server.Conn
+	failed bool
+}
+
+func (s *xoauth2Server) Next(response []byte) ([]byte, bool, error) {
+	if s.failed {
+		return nil, true, errors.New("invalid credentials")
+	}
+	want := "user=username\x01auth=Bearer " + testAccessToken + "\x01\x01"
+	if !bytes.Equal(response, []byte(want)) {
+		s.failed = true
+		return []byte(`{"status":"401"}`), false, nil
+	}
+	return nil, true, authenticate(s.conn)
+}
+
+func authenticate(conn server.Conn) error {
+	user, err := conn.Server().Backend.Login(conn.Info(), "username", "password")
+	if err != nil {
+		return err
+	}
+	conn.Context().State = imap.AuthenticatedState
+	conn.Context().User = user
+	return nil
+}
+
+// startOAuthServer starts a test server that only accepts testAccessToken,
+// with XOAUTH2 and OAUTHBEARER.
+func startOAuthServer(t *testing.T) *config.Config {
+	addr := startTestServer(t, func(srv *server.Server) {
+		srv.EnableAuth("XOAUTH2", func(conn server.Conn) sasl.Server {
+			return &xoauth2Server{conn: conn}
+		})
+		srv.EnableAuth(sasl.OAuthBearer, func(conn server.Conn) sasl.Server {
+			return sasl.NewOAuthBearerServer(func(opts sasl.OAuthBearerOptions) *sasl.OAuthBearerError {
+				if opts.Username != "username" || opts.Token != testAccessToken {
+					return &sasl.OAuthBearerError{Status: "invalid_token"}
+				}
+				if err := authenticate(conn); err != nil {
+					return &sasl.OAuthBearerError{Status: err.Error()}
+				}
+				return nil
+			})
+		})
+	})
+	cfg := &config.Config{}
+	testConfig(cfg, addr)
+	cfg.Password = ""
+	return cfg
+}
+
+func TestConnect_OAuth(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("refresh_token") != "refresh-token" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token": %q, "expires_in": 3600}`, testAccessToken)
+	}))
+	defer endpoint.Close()
+
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{"xoauth2 static token", config.Config{AuthMethod: AuthXOAuth2, OAuthToken: testAccessToken}, false},
+		{"xoauth2 wrong token", config.Config{AuthMethod: AuthXOAuth2, OAuthToken: "expired"}, true},
+		{"oauthbearer refresh token", config.Config{AuthMethod: AuthOAuthBearer, OAuthTokenURL: endpoint.URL, OAuthRefreshToken: "refresh-token"}, false},
+		{"oauthbearer revoked refresh token", config.Config{AuthMethod: AuthOAuthBearer, OAuthTokenURL: endpoint.URL, OAuthRefreshToken: "revoked"}, true},
+		{"xoauth2 token command", config.Config{AuthMethod: AuthXOAuth2, OAuthTokenCommand: "echo " + testAccessToken}, false},
+		{"no token source", config.Config{AuthMethod: AuthXOAuth2}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := startOAuthServer(t)
+			cfg.AuthMethod = tt.cfg.AuthMethod
+			cfg.OAuthToken = tt.cfg.OAuthToken
+			cfg.OAuthTokenURL = tt.cfg.OAuthTokenURL
+			cfg.OAuthRefreshToken = tt.cfg.OAuthRefreshToken
+			cfg.OAuthTokenCommand = tt.cfg.OAuthTokenCommand
+
+			c := NewClient(cfg)
+			err := c.Connect()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Connect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				defer c.client.Logout()
+				if _, err := c.SelectFolder("INBOX"); err != nil {
+					t.Errorf("SelectFolder() error = %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package imap
+
+import "github.com/emersion/go-imap"
+
+// maxRanges bounds the ranges of a UID set sent in one command. Each range is
+// at most 21 characters, which keeps command lines well under the 8192
+// octets RFC 7162 recommends servers accept.
+const maxRanges = 300
+
+// uidBatches coalesces uids into compact sets like "3:10,14,20:25" and splits
+// them so no set has more than maxRanges ranges.
+func uidBatches(uids []uint32) []*imap.SeqSet {
+	all := new(imap.SeqSet)
+	all.AddNum(uids...)
+
+	var batches []*imap.SeqSet
+	for start := 0; start < len(all.Set); start += maxRanges {
+		end := min(start+maxRanges, len(all.Set))
+		batches = append(batches, &imap.SeqSet{Set: all.Set[start:end]})
+	}
+	return batches
+}
+
+// splitBatches groups uids like uidBatches does, so a caller can send one
+// batch at a time and record which ones went through.
+func splitBatches(uids []uint32) [][]uint32 {
+	var batches [][]uint32
+	for _, seqset := range uidBatches(uids) {
+		var batch []uint32
+		for _, seq := range seqset.Set {
+			for uid := seq.Start; ; uid++ {
+				batch = append(batch, uid)
+				if uid == seq.Stop {
+					break
+				}
+			}
+		}
+		batches = append(batches, batch)
+	}
+	return batches
+}
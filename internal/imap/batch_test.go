@@ -0,0 +1,46 @@
+package imap
+
+import "testing"
+
+func TestUIDBatches(t *testing.T) {
+	batches := uidBatches([]uint32{14, 3, 4, 5, 20, 6, 21, 4})
+	if len(batches) != 1 || batches[0].String() != "3:6,14,20:21" {
+		t.Errorf("uidBatches() = %v, want [3:6,14,20:21]", batches)
+	}
+
+	var uids []uint32
+	for uid := uint32(1); uid <= 2*maxRanges+1; uid++ {
+		uids = append(uids, 2*uid)
+	}
+	batches = uidBatches(uids)
+	if len(batches) != 3 {
+		t.Fatalf("got %d batches, want 3", len(batches))
+	}
+	if got := len(batches[2].Set); got != 1 {
+		t.Errorf("last batch has %d ranges, want 1", got)
+	}
+	if got := batches[1].Set[0].Start; got != 2*(maxRanges+1) {
+		t.Errorf("second batch starts at %d, want %d", got, 2*(maxRanges+1))
+	}
+
+	if batches := uidBatches(nil); len(batches) != 0 {
+		t.Errorf("uidBatches(nil) = %v, want none", batches)
+	}
+}
+
+func TestSplitBatches(t *testing.T) {
+	uids := []uint32{9, 1, 2, 3, 7}
+	batches := splitBatches(uids)
+	if len(batches) != 1 {
+		t.Fatalf("got %d batches, want 1", len(batches))
+	}
+	want := []uint32{1, 2, 3, 7, 9}
+	if len(batches[0]) != len(want) {
+		t.Fatalf("splitBatches() = %v, want [%v]", batches, want)
+	}
+	for i := range want {
+		if batches[0][i] != want[i] {
+			t.Fatalf("splitBatches() = %v, want [%v]", batches, want)
+		}
+	}
+}
@@ -1,144 +1,610 @@
 package imap
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"mail-cleaner/internal/config"
+	"mail-cleaner/internal/oauth"
 	"mail-cleaner/internal/rules"
+	"mail-cleaner/internal/state"
+	"os"
+	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
 )
 
 type Client struct {
 	config *config.Config
 	client *client.Client
+	// state remembers the last processed UID of every folder, nil when runs
+	// are not incremental.
+	state *state.Store
+	// updates receives the unilateral updates of every connection, see
+	// Watch.
+	updates chan<- client.Update
+	// tokens gives the access tokens of the OAuth auth methods.
+	tokens oauth.TokenSource
+	// out receives the progress messages, standard output by default.
+	out io.Writer
 }
 
 func NewClient(cfg *config.Config) *Client {
 	return &Client{
 		config: cfg,
 		client: nil,
+		out:    os.Stdout,
 	}
 }
 
+// SetOutput sends the progress messages of the client to w.
+func (c *Client) SetOutput(w io.Writer) {
+	c.out = w
+}
+
+// SetState makes the client record its progress in store instead of loading
+// its state file, so clients that share a state file can share the store.
+func (c *Client) SetState(store *state.Store) {
+	c.state = store
+}
+
 func (c *Client) Connect() error {
 	addr := fmt.Sprintf("%s:%d", c.config.IMAPServer, c.config.IMAPPort)
-	fmt.Printf("Connecting to IMAP server at %s with user %s\n", addr, c.config.Email)
-	client, err := client.DialTLS(addr, nil)
+	fmt.Fprintf(c.out, "Connecting to IMAP server at %s with user %s\n", addr, c.config.Email)
+	client, err := dial(c.config, addr)
 	if err != nil {
 		return fmt.Errorf("failed to connect to IMAP server: %v", err)
 	}
 
 	c.client = client
+	c.client.Updates = c.updates
 
-	if err := c.client.Login(c.config.Email, c.config.Password); err != nil {
+	if err := c.login(); err != nil {
 		return fmt.Errorf("failed to login: %v", err)
 	}
 
-	fmt.Println("Connected and logged in successfully")
+	fmt.Fprintln(c.out, "Connected and logged in successfully")
 
 	return nil
 }
 
 func (c *Client) Disconnect() error {
 	if c.client != nil {
-		fmt.Println("Disconnecting from IMAP server")
+		fmt.Fprintln(c.out, "Disconnecting from IMAP server")
 		err := c.client.Logout()
 		if err != nil {
-			fmt.Printf("Error during logout: %v\n", err)
+			fmt.Fprintf(c.out, "Error during logout: %v\n", err)
 			return fmt.Errorf("failed to logout: %v", err)
 		}
-		fmt.Println("Disconnected successfully")
+		fmt.Fprintln(c.out, "Disconnected successfully")
 	} else {
-		fmt.Println("No active IMAP client to disconnect")
+		fmt.Fprintln(c.out, "No active IMAP client to disconnect")
 	}
 
 	return nil
 }
 
-func (c *Client) ProcessEmails(handler func(*imap.Message) error) error {
-	mbox, err := c.client.Select("INBOX", false)
-	if err != nil {
-		return err
+// fetchItems returns the items to fetch for every email. Header fields and
+// BODYSTRUCTURE are only requested when a loaded rule needs them, which keeps
+// the fetch cheap on large mailboxes.
+func fetchItems(needs rules.Needs) []imap.FetchItem {
+	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchUid, imap.FetchFlags, imap.FetchInternalDate, imap.FetchRFC822Size}
+	if needs.BodyStructure {
+		items = append(items, imap.FetchBodyStructure)
 	}
+	if len(needs.Headers) > 0 {
+		section := &imap.BodySectionName{
+			BodyPartName: imap.BodyPartName{
+				Specifier: imap.HeaderSpecifier,
+				Fields:    needs.Headers,
+			},
+			Peek: true,
+		}
+		items = append(items, section.FetchItem())
+	}
+	return items
+}
 
-	if mbox.Messages == 0 {
-		fmt.Println("No messages in INBOX")
-		return nil
+// bodyFetchLimit caps how much of each body is downloaded for body rules.
+// Text parts come first in practice, attachments after them are cut off.
+const bodyFetchLimit = 256 * 1024
+
+// bodyFetchItems are the items needed to decode a message body: the MIME
+// headers of the top-level part and the start of its text.
+func bodyFetchItems() []imap.FetchItem {
+	header := &imap.BodySectionName{
+		BodyPartName: imap.BodyPartName{
+			Specifier: imap.HeaderSpecifier,
+			Fields:    []string{"Content-Type", "Content-Transfer-Encoding"},
+		},
+		Peek: true,
 	}
+	text := &imap.BodySectionName{
+		BodyPartName: imap.BodyPartName{Specifier: imap.TextSpecifier},
+		Peek:         true,
+		Partial:      []int{0, bodyFetchLimit},
+	}
+	return []imap.FetchItem{imap.FetchUid, header.FetchItem(), text.FetchItem()}
+}
 
-	fmt.Printf("Total messages in INBOX: %d\n", mbox.Messages)
+// FetchBodies fetches the bodies of the given emails and calls handler with
+// each body, one email at a time.
+func (c *Client) FetchBodies(uids []uint32, handler func(*imap.Message) error) error {
+	return c.uidFetch(uidBatches(uids), bodyFetchItems(), handler)
+}
 
-	// For UidFetch use range "1:*" (all UIDs)
-	seqset := new(imap.SeqSet)
-	seqset.AddRange(1, 0)
+// uidFetch fetches items of the emails in each set, one set after the other,
+// and calls handler with each email.
+func (c *Client) uidFetch(seqsets []*imap.SeqSet, items []imap.FetchItem, handler func(*imap.Message) error) error {
+	for _, seqset := range seqsets {
+		messages := make(chan *imap.Message, 10)
+		done := make(chan error, 1)
 
-	messages := make(chan *imap.Message, 10)
-	done := make(chan error, 1)
+		// run fetch in a goroutine
+		go func() {
+			done <- c.client.UidFetch(seqset, items, messages)
+		}()
 
-	// run fetch in a goroutine
-	go func() {
-		done <- c.client.UidFetch(seqset, []imap.FetchItem{imap.FetchEnvelope, imap.FetchUid}, messages)
-	}()
+		for msg := range messages {
+			if err := handler(msg); err != nil {
+				return err
+			}
+		}
 
-	for msg := range messages {
-		if err := handler(msg); err != nil {
+		if err := <-done; err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	return <-done
+// SelectFolder opens folder for processing, read-only for a dry run.
+func (c *Client) SelectFolder(folder string) (*imap.MailboxStatus, error) {
+	// a dry run never needs write access to the mailbox
+	return c.client.Select(folder, c.config.DryRun)
 }
 
-func (c *Client) MarkForDeletion(uid uint32) error {
-	// set up flag for deletion using UID
+// ProcessEmails fetches the emails of the selected folder and calls handler
+// with each of them. When criteria is not nil only the emails found by UID
+// SEARCH are fetched.
+func (c *Client) ProcessEmails(needs rules.Needs, criteria *imap.SearchCriteria, handler func(*imap.Message) error) error {
+	// For UidFetch use range "1:*" (all UIDs)
 	seqset := new(imap.SeqSet)
-	seqset.AddNum(uid)
+	seqset.AddRange(1, 0)
+	seqsets := []*imap.SeqSet{seqset}
+
+	if criteria != nil {
+		uids, err := c.client.UidSearch(criteria)
+		if err != nil {
+			return fmt.Errorf("search failed: %w", err)
+		}
+		fmt.Fprintf(c.out, "Search found %d candidate emails\n", len(uids))
+		if len(uids) == 0 {
+			return nil
+		}
+		seqsets = uidBatches(uids)
+	}
 
+	return c.uidFetch(seqsets, fetchItems(needs), handler)
+}
+
+// MarkForDeletion flags uids \Deleted, see AddFlags.
+func (c *Client) MarkForDeletion(uids []uint32) error {
+	return c.AddFlags(uids, imap.DeletedFlag)
+}
+
+// AddFlags stores the given flags or keywords on all uids, in as few commands
+// as uidBatches allows.
+func (c *Client) AddFlags(uids []uint32, flags ...string) error {
 	item := imap.FormatFlagsOp(imap.AddFlags, true)
-	flags := []interface{}{imap.DeletedFlag}
+	values := make([]interface{}, len(flags))
+	for i, flag := range flags {
+		values[i] = flag
+	}
 
-	return c.client.UidStore(seqset, item, flags, nil)
+	for _, seqset := range uidBatches(uids) {
+		if err := c.client.UidStore(seqset, item, values, nil); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// ExpungeMarked removes every email flagged \Deleted in the selected folder,
+// including those flagged by other clients.
 func (c *Client) ExpungeMarked() error {
 	return c.client.Expunge(nil)
 }
 
-func (c *Client) CleanEmails(rules *rules.Rules) error {
-	var toDelete []uint32
-	processed := 0
+// DeleteMessages flags uids \Deleted and expunges them, see expunge.
+func (c *Client) DeleteMessages(uids []uint32) error {
+	if err := c.MarkForDeletion(uids); err != nil {
+		return fmt.Errorf("failed to mark emails: %w", err)
+	}
+	fmt.Fprintln(c.out, "Expunging marked emails...")
+	return c.expunge(uids)
+}
 
-	err := c.ProcessEmails(func(msg *imap.Message) error {
-		processed++
-		if processed%100 == 0 {
-			fmt.Printf("Processed %d emails...\n", processed)
-		}
+// MoveMessages moves the given UIDs to folder, creating it if needed. UID MOVE
+// is used when the server supports it, otherwise UID COPY, STORE \Deleted and
+// UID EXPUNGE.
+func (c *Client) MoveMessages(uids []uint32, folder string) error {
+	if err := c.ensureFolder(folder); err != nil {
+		return err
+	}
 
-		if rules.ShouldDelete(msg) {
-			toDelete = append(toDelete, msg.Uid)
-			if msg.Envelope != nil && len(msg.Envelope.From) > 0 {
-				fmt.Printf("Marking for deletion: %s - %s\n",
-					msg.Envelope.From[0].MailboxName+"@"+msg.Envelope.From[0].HostName,
-					msg.Envelope.Subject)
+	supportsMove, err := c.client.Support("MOVE")
+	if err != nil {
+		return err
+	}
+	if supportsMove {
+		for _, seqset := range uidBatches(uids) {
+			if err := c.client.UidMove(seqset, folder); err != nil {
+				return err
 			}
 		}
 		return nil
+	}
+
+	for _, seqset := range uidBatches(uids) {
+		if err := c.client.UidCopy(seqset, folder); err != nil {
+			return fmt.Errorf("failed to copy to %s: %w", folder, err)
+		}
+	}
+	if err := c.MarkForDeletion(uids); err != nil {
+		return fmt.Errorf("failed to mark moved emails: %w", err)
+	}
+	return c.expunge(uids)
+}
+
+// expunge removes only the given messages with UID EXPUNGE when the server
+// supports UIDPLUS (RFC 4315), and falls back to a plain EXPUNGE otherwise.
+func (c *Client) expunge(uids []uint32) error {
+	supportsUidPlus, err := c.client.Support("UIDPLUS")
+	if err != nil {
+		return err
+	}
+	if !supportsUidPlus {
+		fmt.Fprintln(c.out, "Server does not support UIDPLUS, falling back to EXPUNGE of every \\Deleted email")
+		return c.ExpungeMarked()
+	}
+
+	for _, seqset := range uidBatches(uids) {
+		cmd := &commands.Uid{Cmd: &imap.Command{
+			Name:      "EXPUNGE",
+			Arguments: []interface{}{seqset},
+		}}
+		status, err := c.client.Execute(cmd, nil)
+		if err != nil {
+			return err
+		}
+		if err := status.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) ensureFolder(folder string) error {
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.client.List("", folder, mailboxes)
+	}()
+
+	exists := false
+	for range mailboxes {
+		exists = true
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("failed to list folder %s: %w", folder, err)
+	}
+	if exists {
+		return nil
+	}
+
+	fmt.Fprintf(c.out, "Creating folder: %s\n", folder)
+	if err := c.client.Create(folder); err != nil {
+		return fmt.Errorf("failed to create folder %s: %w", folder, err)
+	}
+	return nil
+}
+
+type pendingEmail struct {
+	msg     *imap.Message
+	pending *rules.Pending
+}
+
+func (c *Client) CleanEmails(rulesSet *rules.Rules) (*Report, error) {
+	report := &Report{DryRun: c.config.DryRun}
+
+	var folders []string
+	err := c.withReconnect(context.Background(), func() error {
+		var err error
+		folders, err = c.folders()
+		return err
 	})
+	if err != nil {
+		return report, err
+	}
+
+	if c.state == nil && c.config.StateFile != "" {
+		if c.state, err = state.Load(c.config.StateFile); err != nil {
+			return report, err
+		}
+	}
 
+	needs := rulesSet.Needs()
+	if len(needs.Headers) > 0 {
+		fmt.Fprintf(c.out, "Fetching headers: %v\n", needs.Headers)
+	}
+
+	for i, folder := range folders {
+		fmt.Fprintf(c.out, "\nProcessing folder %s (%d/%d)\n", folder, i+1, len(folders))
+		if err := c.cleanFolder(context.Background(), folder, rulesSet, needs, report); err != nil {
+			return report, fmt.Errorf("folder %s: %w", folder, err)
+		}
+	}
+
+	fmt.Fprintf(c.out, "\nTotal emails matched: %d\n", len(report.Entries))
+	return report, nil
+}
+
+// resumeFolder processes folder, skipping what progress says earlier passes
+// over it completed.
+func (c *Client) resumeFolder(folder string, rulesSet *rules.Rules, needs rules.Needs, report *Report, progress *folderProgress) error {
+	stats := report.folder(folder)
+	// rules scoped to other folders never see these emails
+	rulesSet = rulesSet.InFolder(folder)
+
+	// read before SELECT, STATUS of the selected folder can be stale
+	if !progress.started && c.state != nil {
+		var err error
+		if progress.modSeq, err = c.HighestModSeq(folder); err != nil {
+			fmt.Fprintf(c.out, "Warning: %v\n", err)
+		}
+	}
+
+	mbox, err := c.SelectFolder(folder)
+	if err != nil {
+		return err
+	}
+	if progress.started && mbox.UidValidity != progress.uidValidity {
+		// the UIDs seen so far may now belong to other emails
+		fmt.Fprintf(c.out, "UIDVALIDITY of %s changed while reconnecting, processing it again\n", folder)
+		report.resetFolder(folder)
+		progress.reset()
+	}
+	progress.start(mbox.UidValidity)
+
+	if mbox.Messages == 0 {
+		fmt.Fprintf(c.out, "No messages in %s\n", folder)
+		return nil
+	}
+	fmt.Fprintf(c.out, "Total messages in %s: %d\n", folder, mbox.Messages)
+
+	var criteria *imap.SearchCriteria
+	if !c.config.NoSearch {
+		if search, ok := rulesSet.SearchCriteria(); ok {
+			criteria = search
+		} else {
+			fmt.Fprintln(c.out, "Some rules can't be searched for on the server, fetching every email")
+		}
+	}
+
+	saved := c.savedState(folder, mbox)
+	lastUID := saved.LastUID
+	if lastUID > 0 {
+		fmt.Fprintf(c.out, "Skipping emails up to UID %d, already processed\n", lastUID)
+	}
+	from := max(lastUID, progress.fetchedUID)
+	if progress.fetchedUID > lastUID {
+		fmt.Fprintf(c.out, "Resuming after UID %d\n", progress.fetchedUID)
+	}
+	if from > 0 {
+		if criteria == nil {
+			criteria = imap.NewSearchCriteria()
+		}
+		criteria.Uid = new(imap.SeqSet)
+		criteria.Uid.AddRange(from+1, 0)
+	}
+
+	record := func(msg *imap.Message, decision rules.Decision, ok bool) {
+		if !ok || decision.Action == rules.ActionKeep {
+			return
+		}
+
+		if decision.Action == rules.ActionDelete && c.config.MoveTo != "" {
+			decision.Action = rules.ActionMove
+			decision.Target = c.config.MoveTo
+		}
+		if decision.Action == rules.ActionMove && sameFolder(decision.Target, folder) {
+			// already where the rule wants it, e.g. when processing Trash
+			return
+		}
+		report.add(folder, msg, decision)
+
+		if msg.Envelope != nil && len(msg.Envelope.From) > 0 {
+			op := Operation{Action: decision.Action, Target: decision.Target}
+			fmt.Fprintf(c.out, "Marking for %s: %s - %s\n", op,
+				msg.Envelope.From[0].MailboxName+"@"+msg.Envelope.From[0].HostName,
+				msg.Envelope.Subject)
+		}
+	}
+
+	process := func(msg *imap.Message) error {
+		if progress.evaluated[msg.Uid] {
+			return nil
+		}
+		progress.evaluated[msg.Uid] = true
+
+		report.addProcessed(folder, msg)
+		if stats.Processed%100 == 0 {
+			fmt.Fprintf(c.out, "Processed %d emails in %s...\n", stats.Processed, folder)
+		}
+
+		decision, ok, wait := rulesSet.Precheck(msg)
+		if wait != nil {
+			progress.pending[msg.Uid] = &pendingEmail{msg: msg, pending: wait}
+			return nil
+		}
+		record(msg, decision, ok)
+		return nil
+	}
+
+	err = c.ProcessEmails(needs, criteria, func(msg *imap.Message) error {
+		if msg.Uid <= from {
+			// "n:*" always returns the last email, even when older than n
+			return nil
+		}
+		if err := process(msg); err != nil {
+			return err
+		}
+		progress.fetchedUID = max(progress.fetchedUID, msg.Uid)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("\nTotal emails to delete: %d\n", len(toDelete))
+	// emails processed before may match flag rules now
+	if lastUID > 0 && saved.HighestModSeq > 0 && progress.modSeq > saved.HighestModSeq {
+		fmt.Fprintf(c.out, "Fetching emails whose flags changed since the last run...\n")
+		seen := new(imap.SeqSet)
+		seen.AddRange(1, lastUID)
+		if err := c.FetchChanged(seen, saved.HighestModSeq, needs, process); err != nil {
+			return err
+		}
+	}
+
+	// and may be old enough for age rules now
+	if aged, ok := rulesSet.AgedCriteria(saved.LastRun); ok && lastUID > 0 {
+		fmt.Fprintf(c.out, "Searching emails up to UID %d for age rules...\n", lastUID)
+		aged.Uid = new(imap.SeqSet)
+		aged.Uid.AddRange(1, lastUID)
+		if err := c.ProcessEmails(needs, aged, process); err != nil {
+			return err
+		}
+	}
+
+	if len(progress.pending) > 0 {
+		pendingUIDs := make([]uint32, 0, len(progress.pending))
+		for uid := range progress.pending {
+			pendingUIDs = append(pendingUIDs, uid)
+		}
+		fmt.Fprintf(c.out, "Fetching bodies of %d emails for body rules...\n", len(pendingUIDs))
+		err = c.FetchBodies(pendingUIDs, func(bodyMsg *imap.Message) error {
+			email, ok := progress.pending[bodyMsg.Uid]
+			if !ok {
+				return nil
+			}
+			delete(progress.pending, bodyMsg.Uid)
+
+			// keep header sections fetched in the first pass for header rules
+			if email.msg.Body == nil {
+				email.msg.Body = make(map[*imap.BodySectionName]imap.Literal)
+			}
+			for section, literal := range bodyMsg.Body {
+				email.msg.Body[section] = literal
+			}
+			decision, matched := rulesSet.Resume(email.msg, email.pending)
+			record(email.msg, decision, matched)
+
+			// bodies can be large, don't keep them around
+			for section := range bodyMsg.Body {
+				delete(email.msg.Body, section)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(c.out, "Matched in %s: %d\n", folder, stats.Matched)
 
-	//mark emails for deletion
-	for _, uid := range toDelete {
-		if err := c.MarkForDeletion(uid); err != nil {
-			return fmt.Errorf("failed to mark UID %d: %v", uid, err)
+	if c.config.DryRun {
+		fmt.Fprintln(c.out, "Dry run: skipping STORE and EXPUNGE")
+	} else {
+		ops, uids := report.Operations(folder)
+		for _, op := range ops {
+			// one batch at a time, so a resumed pass only repeats the
+			// batch the connection dropped in
+			for _, batch := range splitBatches(progress.unapplied(uids[op])) {
+				if err := c.apply(op, batch); err != nil {
+					return err
+				}
+				progress.markApplied(batch)
+			}
 		}
 	}
 
-	fmt.Println("Expunging marked emails...")
-	return c.ExpungeMarked()
+	return c.saveState(folder, mbox, max(lastUID, progress.fetchedUID), progress.modSeq, progress.startedAt)
+}
+
+func (c *Client) savedState(folder string, mbox *imap.MailboxStatus) state.Folder {
+	if c.state == nil || c.config.FullRescan {
+		return state.Folder{}
+	}
+	saved := c.state.Get(c.account(), folder)
+	if saved.LastUID == 0 {
+		return state.Folder{}
+	}
+	if saved.UIDValidity != mbox.UidValidity {
+		fmt.Fprintf(c.out, "UIDVALIDITY of %s changed (%d -> %d), processing the whole folder\n",
+			folder, saved.UIDValidity, mbox.UidValidity)
+		return state.Folder{}
+	}
+	return saved
+}
+
+// saveState records that every email of folder up to the UID before UIDNEXT
+// has been processed, with flag changes up to modSeq and ages as of lastRun.
+// Servers that don't send UIDNEXT get the highest UID seen instead. A dry run
+// only keeps the state in memory, for watch mode.
+func (c *Client) saveState(folder string, mbox *imap.MailboxStatus, highestUID uint32, modSeq uint64, lastRun time.Time) error {
+	if c.state == nil {
+		return nil
+	}
+	if mbox.UidNext > 1 && mbox.UidNext-1 > highestUID {
+		highestUID = mbox.UidNext - 1
+	}
+	c.state.Set(c.account(), folder, state.Folder{
+		UIDValidity:   mbox.UidValidity,
+		LastUID:       highestUID,
+		HighestModSeq: modSeq,
+		LastRun:       lastRun,
+	})
+	if c.config.DryRun {
+		return nil
+	}
+	return c.state.Save()
+}
+
+func (c *Client) account() string {
+	return state.Account(c.config.Email, c.config.IMAPServer)
+}
+
+func (c *Client) apply(op Operation, uids []uint32) error {
+	fmt.Fprintf(c.out, "Applying %s to %d emails...\n", op, len(uids))
+
+	switch op.Action {
+	case rules.ActionFlag:
+		return c.AddFlags(uids, imap.FlaggedFlag)
+	case rules.ActionMarkRead:
+		return c.AddFlags(uids, imap.SeenFlag)
+	case rules.ActionLabel:
+		return c.AddFlags(uids, op.Target)
+	case rules.ActionMove:
+		if err := c.MoveMessages(uids, op.Target); err != nil {
+			return fmt.Errorf("failed to move emails to %s: %w", op.Target, err)
+		}
+		return nil
+	case rules.ActionDelete:
+		return c.DeleteMessages(uids)
+	default:
+		return fmt.Errorf("unsupported action: %s", op.Action)
+	}
 }
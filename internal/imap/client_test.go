@@ -0,0 +1,592 @@
+package imap
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mail-cleaner/internal/config"
+	"mail-cleaner/internal/rules"
+	"mail-cleaner/internal/rules/rule"
+	"mail-cleaner/internal/state"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+)
+
+// startTestServer serves an in-memory IMAP backend on a loopback address.
+// setup, when not nil, configures the server before it starts, like its TLS
+// or auth mechanisms. Its INBOX starts with one email from
+// contact@example.org with UID 6.
+func startTestServer(t *testing.T, setup func(*server.Server)) net.Addr {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := server.New(memory.New())
+	srv.AllowInsecureAuth = true
+	if setup != nil {
+		setup(srv)
+	}
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+	return listener.Addr()
+}
+
+// testConfig points cfg at the server on addr, reached without TLS.
+func testConfig(cfg *config.Config, addr net.Addr) {
+	tcp := addr.(*net.TCPAddr)
+	cfg.IMAPServer = tcp.IP.String()
+	cfg.IMAPPort = tcp.Port
+	cfg.Email = "username"
+	cfg.Password = "password"
+	if cfg.Security == "" {
+		cfg.Security = SecurityNone
+	}
+}
+
+// newTestClient connects to a new test server, see startTestServer.
+func newTestClient(t *testing.T, cfg *config.Config) *Client {
+	t.Helper()
+	return connectTestClient(t, cfg, startTestServer(t, nil))
+}
+
+// connectTestClient connects to the test server on addr.
+func connectTestClient(t *testing.T, cfg *config.Config, addr net.Addr) *Client {
+	t.Helper()
+
+	testConfig(cfg, addr)
+	c := NewClient(cfg)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	t.Cleanup(func() { c.client.Logout() })
+	return c
+}
+
+func appendEmail(t *testing.T, c *Client, folder, from, subject string) {
+	t.Helper()
+	body := "From: " + from + "\r\n" +
+		"To: username@example.org\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: Wed, 11 May 2016 14:31:59 +0000\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Hello"
+	if err := c.client.Append(folder, nil, time.Now(), bytes.NewBufferString(body)); err != nil {
+		t.Fatalf("failed to append email: %v", err)
+	}
+}
+
+func flagRule(t *testing.T, domain string) rules.Rule {
+	t.Helper()
+	domainRule, err := rule.NewDomainRule(domain)
+	if err != nil {
+		t.Fatalf("NewDomainRule() error = %v", err)
+	}
+	flagged, err := rules.WithAction(domainRule, rules.ActionFlag, "")
+	if err != nil {
+		t.Fatalf("WithAction() error = %v", err)
+	}
+	return flagged
+}
+
+func moveRule(t *testing.T, domain, folder string) rules.Rule {
+	t.Helper()
+	domainRule, err := rule.NewDomainRule(domain)
+	if err != nil {
+		t.Fatalf("NewDomainRule() error = %v", err)
+	}
+	moved, err := rules.WithAction(domainRule, rules.ActionMove, folder)
+	if err != nil {
+		t.Fatalf("WithAction() error = %v", err)
+	}
+	return moved
+}
+
+// mailboxSnapshot returns the flags of every email, by folder and UID.
+func mailboxSnapshot(t *testing.T, c *Client) map[string]map[uint32][]string {
+	t.Helper()
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	if err := c.client.List("", "*", mailboxes); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	snapshot := make(map[string]map[uint32][]string)
+	for info := range mailboxes {
+		flags := make(map[uint32][]string)
+		snapshot[info.Name] = flags
+
+		mbox, err := c.client.Select(info.Name, true)
+		if err != nil {
+			t.Fatalf("Select(%s) error = %v", info.Name, err)
+		}
+		if mbox.Messages == 0 {
+			continue
+		}
+		messages := make(chan *imap.Message, 10)
+		done := make(chan error, 1)
+		go func() {
+			seqset, _ := imap.ParseSeqSet("1:*")
+			done <- c.client.Fetch(seqset, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, messages)
+		}()
+		for msg := range messages {
+			flags[msg.Uid] = msg.Flags
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("Fetch(%s) error = %v", info.Name, err)
+		}
+	}
+	return snapshot
+}
+
+func matchedUIDs(report *Report) []uint32 {
+	var uids []uint32
+	for _, entry := range report.Entries {
+		uids = append(uids, entry.UID)
+	}
+	return uids
+}
+
+func TestCleanEmails_Incremental(t *testing.T) {
+	cfg := &config.Config{StateFile: filepath.Join(t.TempDir(), "state.json")}
+	c := newTestClient(t, cfg)
+	appendEmail(t, c, "INBOX", "promo@spam.com", "Sale")
+	set := rules.NewRules([]rules.Rule{flagRule(t, "spam.com")})
+
+	report, err := c.CleanEmails(set)
+	if err != nil {
+		t.Fatalf("CleanEmails() error = %v", err)
+	}
+	if got := matchedUIDs(report); len(got) != 1 || got[0] != 7 {
+		t.Fatalf("first run matched %v, want [7]", got)
+	}
+
+	report, err = c.CleanEmails(set)
+	if err != nil {
+		t.Fatalf("CleanEmails() error = %v", err)
+	}
+	if report.Processed != 0 || len(report.Entries) != 0 {
+		t.Errorf("second run processed %d and matched %v, want nothing", report.Processed, matchedUIDs(report))
+	}
+
+	appendEmail(t, c, "INBOX", "promo@spam.com", "Another sale")
+	report, err = c.CleanEmails(set)
+	if err != nil {
+		t.Fatalf("CleanEmails() error = %v", err)
+	}
+	if got := matchedUIDs(report); len(got) != 1 || got[0] != 8 {
+		t.Errorf("run after a new email matched %v, want [8]", got)
+	}
+
+	cfg.FullRescan = true
+	report, err = c.CleanEmails(set)
+	if err != nil {
+		t.Fatalf("CleanEmails() error = %v", err)
+	}
+	if got := matchedUIDs(report); len(got) != 2 {
+		t.Errorf("full rescan matched %v, want [7 8]", got)
+	}
+}
+
+func TestCleanEmails_IncrementalAgeRules(t *testing.T) {
+	cfg := &config.Config{StateFile: filepath.Join(t.TempDir(), "state.json")}
+	c := newTestClient(t, cfg)
+	appendEmail(t, c, "INBOX", "friend@example.com", "Hello")
+	if _, err := c.CleanEmails(rules.NewRules([]rules.Rule{flagRule(t, "spam.com")})); err != nil {
+		t.Fatalf("CleanEmails() error = %v", err)
+	}
+
+	// as if the first run was before the emails, sent on 11 May 2016, were
+	// 30 days old
+	saved := c.state.Get(c.account(), "INBOX")
+	saved.LastRun = time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+	c.state.Set(c.account(), "INBOX", saved)
+
+	old, err := rule.NewDateRule(&rule.DateRule{OlderThan: 30 * 24 * time.Hour, Source: rule.DateHeader})
+	if err != nil {
+		t.Fatalf("NewDateRule() error = %v", err)
+	}
+	flagged, err := rules.WithAction(old, rules.ActionFlag, "")
+	if err != nil {
+		t.Fatalf("WithAction() error = %v", err)
+	}
+	set := rules.NewRules([]rules.Rule{flagRule(t, "spam.com"), flagged})
+
+	// the memory server's own email in INBOX got old enough too
+	for run, want := range [][]uint32{{6, 7}, nil, nil} {
+		report, err := c.CleanEmails(set)
+		if err != nil {
+			t.Fatalf("CleanEmails() error = %v", err)
+		}
+		if got := matchedUIDs(report); !reflect.DeepEqual(got, want) {
+			t.Errorf("run %d matched %v, want %v", run+2, got, want)
+		}
+	}
+}
+
+func TestCleanEmails_UIDValidityChanged(t *testing.T) {
+	cfg := &config.Config{StateFile: filepath.Join(t.TempDir(), "state.json")}
+	c := newTestClient(t, cfg)
+	appendEmail(t, c, "INBOX", "promo@spam.com", "Sale")
+
+	// recorded against another UIDVALIDITY, the memory server always uses 1
+	store, err := state.Load(cfg.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set(c.account(), "INBOX", state.Folder{UIDValidity: 99, LastUID: 100})
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := c.CleanEmails(rules.NewRules([]rules.Rule{flagRule(t, "spam.com")}))
+	if err != nil {
+		t.Fatalf("CleanEmails() error = %v", err)
+	}
+	if got := matchedUIDs(report); len(got) != 1 || got[0] != 7 {
+		t.Errorf("CleanEmails() matched %v, want [7] after UIDVALIDITY reset", got)
+	}
+}
+
+func TestCleanEmails_DryRunKeepsState(t *testing.T) {
+	cfg := &config.Config{StateFile: filepath.Join(t.TempDir(), "state.json"), DryRun: true}
+	c := newTestClient(t, cfg)
+	appendEmail(t, c, "INBOX", "promo@spam.com", "Sale")
+
+	report, err := c.CleanEmails(rules.NewRules([]rules.Rule{flagRule(t, "spam.com")}))
+	if err != nil {
+		t.Fatalf("CleanEmails() error = %v", err)
+	}
+	if len(report.Entries) != 1 {
+		t.Errorf("dry run matched %v, want [7]", matchedUIDs(report))
+	}
+	if _, err := os.Stat(cfg.StateFile); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote the state file")
+	}
+}
+
+func TestCleanEmails_DryRunChangesNothing(t *testing.T) {
+	c := newTestClient(t, &config.Config{DryRun: true})
+	appendEmail(t, c, "INBOX", "promo@spam.com", "Sale")
+	appendEmail(t, c, "INBOX", "news@letters.com", "News")
+	domainRule, err := rule.NewDomainRule("spam.com")
+	if err != nil {
+		t.Fatalf("NewDomainRule() error = %v", err)
+	}
+	set := rules.NewRules([]rules.Rule{domainRule, moveRule(t, "letters.com", "Archive")})
+	before := mailboxSnapshot(t, c)
+
+	report, err := c.CleanEmails(set)
+	if err != nil {
+		t.Fatalf("CleanEmails() error = %v", err)
+	}
+	if len(report.Entries) != 2 || report.Entries[0].Action != rules.ActionDelete || report.Entries[1].Action != rules.ActionMove {
+		t.Fatalf("dry run reported %+v, want a delete of UID 7 and a move of UID 8", report.Entries)
+	}
+
+	if after := mailboxSnapshot(t, c); !reflect.DeepEqual(after, before) {
+		t.Errorf("dry run changed the mailbox to %v, want %v", after, before)
+	}
+}
+
+func TestCleanEmails_Delete(t *testing.T) {
+	c := newTestClient(t, &config.Config{})
+	appendEmail(t, c, "INBOX", "promo@spam.com", "Sale")
+	appendEmail(t, c, "INBOX", "friend@example.org", "Hi")
+	appendEmail(t, c, "INBOX", "news@spam.com", "News")
+	domainRule, err := rule.NewDomainRule("spam.com")
+	if err != nil {
+		t.Fatalf("NewDomainRule() error = %v", err)
+	}
+
+	report, err := c.CleanEmails(rules.NewRules([]rules.Rule{domainRule}))
+	if err != nil {
+		t.Fatalf("CleanEmails() error = %v", err)
+	}
+	if got := matchedUIDs(report); len(got) != 2 {
+		t.Fatalf("CleanEmails() matched %v, want [7 9]", got)
+	}
+
+	mbox, err := c.SelectFolder("INBOX")
+	if err != nil {
+		t.Fatalf("SelectFolder() error = %v", err)
+	}
+	if mbox.Messages != 2 {
+		t.Errorf("INBOX has %d emails after cleaning, want 2", mbox.Messages)
+	}
+}
+
+func TestCleanEmails_DeleteWithUIDPlus(t *testing.T) {
+	addr := startTestServer(t, func(srv *server.Server) {
+		srv.Enable(uidPlus{})
+	})
+	c := connectTestClient(t, &config.Config{}, addr)
+	appendEmail(t, c, "INBOX", "promo@spam.com", "Sale")
+	appendEmail(t, c, "INBOX", "friend@example.org", "Hi")
+	appendEmail(t, c, "INBOX", "news@spam.com", "News")
+
+	// another client marks an email it will expunge itself later
+	other := connectTestClient(t, &config.Config{}, addr)
+	if _, err := other.SelectFolder("INBOX"); err != nil {
+		t.Fatalf("SelectFolder() error = %v", err)
+	}
+	if err := other.MarkForDeletion([]uint32{8}); err != nil {
+		t.Fatalf("MarkForDeletion() error = %v", err)
+	}
+
+	domainRule, err := rule.NewDomainRule("spam.com")
+	if err != nil {
+		t.Fatalf("NewDomainRule() error = %v", err)
+	}
+	report, err := c.CleanEmails(rules.NewRules([]rules.Rule{domainRule}))
+	if err != nil {
+		t.Fatalf("CleanEmails() error = %v", err)
+	}
+	if got := matchedUIDs(report); len(got) != 2 {
+		t.Fatalf("CleanEmails() matched %v, want [7 9]", got)
+	}
+
+	if _, err := c.SelectFolder("INBOX"); err != nil {
+		t.Fatalf("SelectFolder() error = %v", err)
+	}
+	uids, err := c.client.UidSearch(imap.NewSearchCriteria())
+	if err != nil {
+		t.Fatalf("UidSearch() error = %v", err)
+	}
+	if len(uids) != 2 || uids[0] != 6 || uids[1] != 8 {
+		t.Errorf("INBOX has UIDs %v after cleaning, want [6 8]", uids)
+	}
+}
+
+// uidPlus adds UID EXPUNGE (RFC 4315) to the test server.
+type uidPlus struct{}
+
+func (uidPlus) Capabilities(server.Conn) []string {
+	return []string{"UIDPLUS"}
+}
+
+func (uidPlus) Command(name string) server.HandlerFactory {
+	if name != "EXPUNGE" {
+		return nil
+	}
+	return func() server.Handler { return &uidExpunge{} }
+}
+
+// uidExpunge is EXPUNGE, and with UID only expunges the emails in uids.
+type uidExpunge struct {
+	server.Expunge
+	uids *imap.SeqSet
+}
+
+func (cmd *uidExpunge) Parse(fields []interface{}) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	set, ok := fields[0].(string)
+	if !ok {
+		return errors.New("UID EXPUNGE needs a UID set")
+	}
+	var err error
+	cmd.uids, err = imap.ParseSeqSet(set)
+	return err
+}
+
+// UidHandle keeps \Deleted emails outside uids away from the memory
+// backend's EXPUNGE, which removes them all.
+func (cmd *uidExpunge) UidHandle(conn server.Conn) error {
+	mbox := conn.Context().Mailbox
+	if mbox == nil {
+		return server.ErrNoMailboxSelected
+	}
+	deleted, err := mbox.SearchMessages(true, &imap.SearchCriteria{WithFlags: []string{imap.DeletedFlag}})
+	if err != nil {
+		return err
+	}
+	keep := new(imap.SeqSet)
+	for _, uid := range deleted {
+		if !cmd.uids.Contains(uid) {
+			keep.AddNum(uid)
+		}
+	}
+	if keep.Empty() {
+		return mbox.Expunge()
+	}
+
+	flags := []string{imap.DeletedFlag}
+	if err := mbox.UpdateMessagesFlags(true, keep, imap.RemoveFlags, flags); err != nil {
+		return err
+	}
+	if err := mbox.Expunge(); err != nil {
+		return err
+	}
+	return mbox.UpdateMessagesFlags(true, keep, imap.AddFlags, flags)
+}
+
+// moveServer starts a test server whose folders support MOVE, which the
+// memory backend advertises but doesn't implement. noMove hides MOVE from
+// the capabilities instead.
+func moveServer(t *testing.T, noMove bool) (net.Addr, *moveBackend) {
+	t.Helper()
+	be := &moveBackend{noMove: noMove}
+	addr := startTestServer(t, func(srv *server.Server) {
+		be.Backend = srv.Backend
+		srv.Backend = be
+		srv.Enable(be)
+	})
+	return addr, be
+}
+
+// moveBackend counts the MOVE and COPY commands it gets.
+type moveBackend struct {
+	backend.Backend
+	noMove bool
+
+	moves, copies atomic.Int32
+}
+
+func (be *moveBackend) Login(connInfo *imap.ConnInfo, username, password string) (backend.User, error) {
+	user, err := be.Backend.Login(connInfo, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return &moveUser{User: user, be: be}, nil
+}
+
+func (be *moveBackend) Capabilities(server.Conn) []string {
+	return nil
+}
+
+func (be *moveBackend) Command(string) server.HandlerFactory {
+	return nil
+}
+
+func (be *moveBackend) NewConn(conn server.Conn) server.Conn {
+	return &moveConn{Conn: conn, noMove: be.noMove}
+}
+
+type moveUser struct {
+	backend.User
+	be *moveBackend
+}
+
+func (u *moveUser) GetMailbox(name string) (backend.Mailbox, error) {
+	mbox, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	return &moveMailbox{Mailbox: mbox, be: u.be}, nil
+}
+
+type moveMailbox struct {
+	backend.Mailbox
+	be *moveBackend
+}
+
+func (m *moveMailbox) CopyMessages(uid bool, seqset *imap.SeqSet, dest string) error {
+	m.be.copies.Add(1)
+	return m.Mailbox.CopyMessages(uid, seqset, dest)
+}
+
+// MoveMessages copies seqset to dest and expunges it. The memory backend
+// can't expunge single emails, so this expunges every \Deleted email, which
+// is fine as long as tests don't flag others.
+func (m *moveMailbox) MoveMessages(uid bool, seqset *imap.SeqSet, dest string) error {
+	m.be.moves.Add(1)
+	if err := m.Mailbox.CopyMessages(uid, seqset, dest); err != nil {
+		return err
+	}
+	if err := m.Mailbox.UpdateMessagesFlags(uid, seqset, imap.AddFlags, []string{imap.DeletedFlag}); err != nil {
+		return err
+	}
+	return m.Mailbox.Expunge()
+}
+
+// moveConn hides MOVE when noMove is set.
+type moveConn struct {
+	server.Conn
+	noMove bool
+}
+
+func (c *moveConn) Capabilities() []string {
+	var caps []string
+	for _, capability := range c.Conn.Capabilities() {
+		if capability != "MOVE" || !c.noMove {
+			caps = append(caps, capability)
+		}
+	}
+	return caps
+}
+
+func TestMoveMessages(t *testing.T) {
+	tests := []struct {
+		name       string
+		noMove     bool
+		wantMoves  int32
+		wantCopies int32
+	}{
+		{name: "UID MOVE", wantMoves: 1},
+		{name: "COPY and EXPUNGE without MOVE", noMove: true, wantCopies: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, be := moveServer(t, tt.noMove)
+			c := connectTestClient(t, &config.Config{}, addr)
+			appendEmail(t, c, "INBOX", "promo@spam.com", "Sale")
+			appendEmail(t, c, "INBOX", "friend@example.org", "Hi")
+			if _, err := c.SelectFolder("INBOX"); err != nil {
+				t.Fatalf("SelectFolder() error = %v", err)
+			}
+
+			// Archive doesn't exist yet, ensureFolder creates it
+			if err := c.MoveMessages([]uint32{6, 7}, "Archive"); err != nil {
+				t.Fatalf("MoveMessages() error = %v", err)
+			}
+
+			snapshot := mailboxSnapshot(t, c)
+			if len(snapshot["Archive"]) != 2 {
+				t.Errorf("Archive has %v, want 2 emails", snapshot["Archive"])
+			}
+			if inbox := snapshot["INBOX"]; len(inbox) != 1 || inbox[8] == nil {
+				t.Errorf("INBOX has %v, want only UID 8", inbox)
+			}
+			if moves, copies := be.moves.Load(), be.copies.Load(); moves != tt.wantMoves || copies != tt.wantCopies {
+				t.Errorf("got %d MOVE and %d COPY, want %d and %d", moves, copies, tt.wantMoves, tt.wantCopies)
+			}
+		})
+	}
+}
+
+func TestCleanEmails_MoveTo(t *testing.T) {
+	addr, _ := moveServer(t, false)
+	c := connectTestClient(t, &config.Config{MoveTo: "Trash"}, addr)
+	appendEmail(t, c, "INBOX", "promo@spam.com", "Sale")
+	domainRule, err := rule.NewDomainRule("spam.com")
+	if err != nil {
+		t.Fatalf("NewDomainRule() error = %v", err)
+	}
+
+	report, err := c.CleanEmails(rules.NewRules([]rules.Rule{domainRule}))
+	if err != nil {
+		t.Fatalf("CleanEmails() error = %v", err)
+	}
+	if len(report.Entries) != 1 || report.Entries[0].Action != rules.ActionMove || report.Entries[0].Target != "Trash" {
+		t.Fatalf("CleanEmails() reported %+v, want a move of UID 7 to Trash", report.Entries)
+	}
+
+	snapshot := mailboxSnapshot(t, c)
+	if len(snapshot["Trash"]) != 1 || len(snapshot["INBOX"]) != 1 {
+		t.Errorf("mailbox = %v, want UID 7 in Trash instead of deleted", snapshot)
+	}
+}
@@ -0,0 +1,92 @@
+package imap
+
+import (
+	"fmt"
+	"mail-cleaner/internal/rules"
+	"strconv"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+)
+
+// CONDSTORE (RFC 7162) gives every flag change a modification sequence, so
+// emails whose flags changed since the last run can be refetched without
+// rescanning the folder.
+
+const statusHighestModSeq imap.StatusItem = "HIGHESTMODSEQ"
+
+// HighestModSeq returns the HIGHESTMODSEQ of folder, or zero when the server
+// doesn't support CONDSTORE or the folder has no modification sequences. It
+// must be called before the folder is selected.
+func (c *Client) HighestModSeq(folder string) (uint64, error) {
+	supported, err := c.client.Support("CONDSTORE")
+	if err != nil || !supported {
+		return 0, err
+	}
+
+	status, err := c.client.Status(folder, []imap.StatusItem{statusHighestModSeq})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get HIGHESTMODSEQ of %s: %w", folder, err)
+	}
+	modSeq, _ := parseModSeq(status.Items[statusHighestModSeq])
+	return modSeq, nil
+}
+
+// parseModSeq reads a mod-sequence, a 63-bit number that may not fit the
+// uint32 go-imap parses numbers into.
+func parseModSeq(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case uint32:
+		return uint64(v), true
+	case string:
+		n, err := strconv.ParseUint(v, 10, 64)
+		return n, err == nil
+	case imap.RawString:
+		n, err := strconv.ParseUint(string(v), 10, 64)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// changedSince is a FETCH with the CHANGEDSINCE modifier.
+type changedSince struct {
+	commands.Fetch
+	ModSeq uint64
+}
+
+func (cmd *changedSince) Command() *imap.Command {
+	fetch := cmd.Fetch.Command()
+	modifier := []interface{}{imap.RawString("CHANGEDSINCE"), imap.RawString(strconv.FormatUint(cmd.ModSeq, 10))}
+	fetch.Arguments = append(fetch.Arguments, modifier)
+	return fetch
+}
+
+// FetchChanged fetches the emails in uids whose flags changed after modSeq
+// and calls handler with each of them.
+func (c *Client) FetchChanged(uids *imap.SeqSet, modSeq uint64, needs rules.Needs, handler func(*imap.Message) error) error {
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+
+	go func() {
+		defer close(messages)
+		cmd := &commands.Uid{Cmd: &changedSince{
+			Fetch:  commands.Fetch{SeqSet: uids, Items: fetchItems(needs)},
+			ModSeq: modSeq,
+		}}
+		status, err := c.client.Execute(cmd, &responses.Fetch{Messages: messages, SeqSet: uids, Uid: true})
+		if err == nil {
+			err = status.Err()
+		}
+		done <- err
+	}()
+
+	for msg := range messages {
+		if err := handler(msg); err != nil {
+			return err
+		}
+	}
+
+	return <-done
+}
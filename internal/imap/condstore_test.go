@@ -0,0 +1,51 @@
+package imap
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+)
+
+func TestParseModSeq(t *testing.T) {
+	tests := []struct {
+		value  interface{}
+		want   uint64
+		wantOk bool
+	}{
+		{value: uint32(1234), want: 1234, wantOk: true},
+		{value: "90071992547409920", want: 90071992547409920, wantOk: true},
+		{value: imap.RawString("42"), want: 42, wantOk: true},
+		{value: nil},
+		{value: "NIL"},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseModSeq(tt.value)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("parseModSeq(%#v) = %d, %v, want %d, %v", tt.value, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestChangedSince_Command(t *testing.T) {
+	uids := new(imap.SeqSet)
+	uids.AddRange(1, 500)
+	cmd := &commands.Uid{Cmd: &changedSince{
+		Fetch:  commands.Fetch{SeqSet: uids, Items: []imap.FetchItem{imap.FetchUid, imap.FetchFlags}},
+		ModSeq: 90071992547409920,
+	}}
+
+	var buf bytes.Buffer
+	command := cmd.Command()
+	command.Tag = "a1"
+	if err := command.WriteTo(imap.NewWriter(&buf)); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+
+	want := "a1 UID FETCH 1:500 (UID FLAGS) (CHANGEDSINCE 90071992547409920)\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("command = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+package imap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"mail-cleaner/internal/config"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// Connection security modes, see config.Config.Security.
+const (
+	SecurityTLS      = "tls"
+	SecurityStartTLS = "starttls"
+	SecurityNone     = "none"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// dial opens a connection to addr protected as cfg.Security says. STARTTLS
+// never falls back to plaintext when the server doesn't offer it.
+func dial(cfg *config.Config, addr string) (*client.Client, error) {
+	switch strings.ToLower(cfg.Security) {
+	case "", SecurityTLS:
+		tlsConfig, err := tlsConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return client.DialTLS(addr, tlsConfig)
+	case SecurityStartTLS:
+		tlsConfig, err := tlsConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		c, err := client.Dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := c.SupportStartTLS()
+		if err == nil && !ok {
+			err = fmt.Errorf("server does not support STARTTLS")
+		}
+		if err == nil {
+			err = c.StartTLS(tlsConfig)
+		}
+		if err != nil {
+			c.Terminate()
+			return nil, err
+		}
+		return c, nil
+	case SecurityNone:
+		if !isLoopback(cfg.IMAPServer) {
+			return nil, fmt.Errorf("security %q is only allowed for localhost, not %s", SecurityNone, cfg.IMAPServer)
+		}
+		return client.Dial(addr)
+	default:
+		return nil, fmt.Errorf("unknown security %q, use %s, %s or %s", cfg.Security, SecurityTLS, SecurityStartTLS, SecurityNone)
+	}
+}
+
+// tlsConfig builds the TLS settings of cfg. Nil fields keep Go's defaults,
+// like the system CAs.
+func tlsConfig(cfg *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: cfg.TLSServerName}
+
+	if cfg.TLSMinVersion != "" {
+		version, ok := tlsVersions[strings.TrimPrefix(cfg.TLSMinVersion, "TLS")]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", cfg.TLSMinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if cfg.TLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.TLSCAFile)
+		}
+	}
+
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+			return nil, fmt.Errorf("a client certificate needs both a certificate and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// isLoopback reports whether host is localhost or a loopback address. Names
+// other than localhost are not resolved, DNS could point them anywhere.
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
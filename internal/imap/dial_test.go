@@ -0,0 +1,142 @@
+package imap
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mail-cleaner/internal/config"
+
+	"github.com/emersion/go-imap/server"
+)
+
+// selfSigned returns a server certificate for 127.0.0.1 and the path of a
+// CA file trusting it.
+func selfSigned(t *testing.T) (tls.Certificate, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mail-cleaner test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:              []string{"imap.test"},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+func TestConnect_StartTLS(t *testing.T) {
+	cert, caFile := selfSigned(t)
+	addr := startTestServer(t, func(srv *server.Server) {
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	})
+
+	cfg := &config.Config{Security: SecurityStartTLS, TLSCAFile: caFile, TLSMinVersion: "1.3"}
+	testConfig(cfg, addr)
+	c := NewClient(cfg)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer c.client.Logout()
+	if !c.client.IsTLS() {
+		t.Errorf("connection is not encrypted")
+	}
+
+	// the certificate is for 127.0.0.1 and imap.test only
+	cfg.TLSServerName = "other.test"
+	if err := NewClient(cfg).Connect(); err == nil {
+		t.Errorf("Connect() with a wrong server name succeeded")
+	}
+
+	cfg.TLSServerName = ""
+	cfg.TLSCAFile = ""
+	if err := NewClient(cfg).Connect(); err == nil {
+		t.Errorf("Connect() without the CA succeeded")
+	}
+}
+
+func TestConnect_StartTLSRequired(t *testing.T) {
+	cfg := &config.Config{Security: SecurityStartTLS}
+	testConfig(cfg, startTestServer(t, nil))
+	if err := NewClient(cfg).Connect(); err == nil {
+		t.Errorf("Connect() fell back to plaintext")
+	}
+}
+
+func TestDial_NoneOnlyOnLoopback(t *testing.T) {
+	cfg := &config.Config{IMAPServer: "imap.example.com", IMAPPort: 143, Security: SecurityNone}
+	if _, err := dial(cfg, "imap.example.com:143"); err == nil {
+		t.Errorf("dial() allowed plaintext to a remote server")
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := map[string]bool{
+		"localhost":        true,
+		"127.0.0.1":        true,
+		"127.1.2.3":        true,
+		"::1":              true,
+		"10.0.0.1":         false,
+		"imap.example.com": false,
+		"localhost.evil":   false,
+	}
+	for host, want := range tests {
+		if got := isLoopback(host); got != want {
+			t.Errorf("isLoopback(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	got, err := tlsConfig(&config.Config{TLSMinVersion: "1.3", TLSServerName: "imap.test"})
+	if err != nil {
+		t.Fatalf("tlsConfig() error = %v", err)
+	}
+	if got.MinVersion != tls.VersionTLS13 || got.ServerName != "imap.test" {
+		t.Errorf("tlsConfig() = min version %x, server name %q", got.MinVersion, got.ServerName)
+	}
+
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	invalid := []*config.Config{
+		{TLSMinVersion: "2.0"},
+		{TLSCAFile: notPEM},
+		{TLSCAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		{TLSCertFile: "client.pem"},
+	}
+	for _, cfg := range invalid {
+		if _, err := tlsConfig(cfg); err == nil {
+			t.Errorf("tlsConfig(%+v) succeeded, want an error", cfg)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package imap
+
+import (
+	"fmt"
+	"mail-cleaner/internal/rules"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// AllFolders selects every folder of the account.
+const AllFolders = "all"
+
+const defaultFolder = "INBOX"
+
+// ListFolders returns the names of all folders that can be selected.
+func (c *Client) ListFolders() ([]string, error) {
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.client.List("", "*", mailboxes)
+	}()
+
+	var folders []string
+	for info := range mailboxes {
+		if hasAttr(info, imap.NoSelectAttr) {
+			continue
+		}
+		folders = append(folders, info.Name)
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+	return folders, nil
+}
+
+func hasAttr(info *imap.MailboxInfo, attr string) bool {
+	for _, a := range info.Attributes {
+		if strings.EqualFold(a, attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// folders resolves the configured folder patterns against the folders on the
+// server. Only INBOX is processed when nothing is configured.
+func (c *Client) folders() ([]string, error) {
+	patterns := c.config.Folders
+	if len(patterns) == 0 {
+		patterns = []string{defaultFolder}
+	}
+
+	available, err := c.ListFolders()
+	if err != nil {
+		return nil, err
+	}
+
+	selected, unmatched := matchFolders(patterns, available)
+	for _, pattern := range unmatched {
+		fmt.Fprintf(c.out, "No folder matches %q, skipping\n", pattern)
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no folders to process")
+	}
+	return selected, nil
+}
+
+// matchFolders returns the available folders matching any of the patterns,
+// in pattern order and without duplicates, and the patterns that matched
+// nothing. A pattern is a folder name, a glob where * and ? also match the
+// hierarchy delimiter ("Newsletters/*"), or "all".
+func matchFolders(patterns, available []string) (selected, unmatched []string) {
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matcher := folderMatcher(pattern)
+		found := false
+		for _, folder := range available {
+			if !matcher(folder) {
+				continue
+			}
+			found = true
+			if !seen[folder] {
+				seen[folder] = true
+				selected = append(selected, folder)
+			}
+		}
+		if !found {
+			unmatched = append(unmatched, pattern)
+		}
+	}
+	return selected, unmatched
+}
+
+func folderMatcher(pattern string) func(string) bool {
+	if strings.EqualFold(pattern, AllFolders) {
+		return func(string) bool { return true }
+	}
+	return func(folder string) bool { return rules.MatchFolder(pattern, folder) }
+}
+
+// sameFolder compares folder names. INBOX is case-insensitive (RFC 3501),
+// other names are not.
+func sameFolder(a, b string) bool {
+	if strings.EqualFold(a, defaultFolder) {
+		return strings.EqualFold(b, defaultFolder)
+	}
+	return a == b
+}
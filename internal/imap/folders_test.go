@@ -0,0 +1,38 @@
+package imap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchFolders(t *testing.T) {
+	available := []string{"INBOX", "Sent", "Trash", "Newsletters", "Newsletters/Tech", "Newsletters/Shops/Old", "Archive/2023"}
+
+	tests := []struct {
+		name          string
+		patterns      []string
+		want          []string
+		wantUnmatched []string
+	}{
+		{name: "inbox any case", patterns: []string{"inbox"}, want: []string{"INBOX"}},
+		{name: "explicit names", patterns: []string{"Trash", "Sent"}, want: []string{"Trash", "Sent"}},
+		{name: "names are case-sensitive", patterns: []string{"trash"}, wantUnmatched: []string{"trash"}},
+		{name: "glob crosses hierarchy", patterns: []string{"Newsletters/*"}, want: []string{"Newsletters/Tech", "Newsletters/Shops/Old"}},
+		{name: "question mark", patterns: []string{"Archive/202?"}, want: []string{"Archive/2023"}},
+		{name: "no duplicates", patterns: []string{"Newsletters*", "Newsletters/Tech"}, want: []string{"Newsletters", "Newsletters/Tech", "Newsletters/Shops/Old"}},
+		{name: "all", patterns: []string{"all"}, want: available},
+		{name: "unmatched", patterns: []string{"INBOX", "Spam"}, want: []string{"INBOX"}, wantUnmatched: []string{"Spam"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, unmatched := matchFolders(tt.patterns, available)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchFolders() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(unmatched, tt.wantUnmatched) {
+				t.Errorf("matchFolders() unmatched = %v, want %v", unmatched, tt.wantUnmatched)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package imap
+
+import (
+	"fmt"
+	"io"
+	"mail-cleaner/internal/rules"
+	"sort"
+
+	"github.com/emersion/go-imap"
+)
+
+type ReportEntry struct {
+	Folder  string
+	UID     uint32
+	From    string
+	Subject string
+	Rule    string
+	Size    uint32
+	Action  rules.Action
+	// Target is the folder for move and the keyword for label.
+	Target string
+}
+
+// Operation is an action applied to a group of emails in one go.
+type Operation struct {
+	Action rules.Action
+	Target string
+}
+
+// SenderStats is how much mailbox space one sender takes.
+type SenderStats struct {
+	Sender string
+	Count  int
+	Bytes  uint64
+}
+
+// FolderStats are the totals of one processed folder.
+type FolderStats struct {
+	Name      string
+	Processed int
+	Matched   int
+	// Bytes is the total size of the matched emails.
+	Bytes uint64
+}
+
+type Report struct {
+	DryRun    bool
+	Processed int
+	Entries   []ReportEntry
+	// Folders are in processing order.
+	Folders []*FolderStats
+	// Senders holds totals for every processed email, matched or not.
+	Senders map[string]*SenderStats
+}
+
+func (r *Report) add(folder string, msg *imap.Message, decision rules.Decision) {
+	entry := ReportEntry{
+		Folder: folder,
+		UID:    msg.Uid,
+		Rule:   decision.Reason,
+		Size:   msg.Size,
+		Action: decision.Action,
+		Target: decision.Target,
+	}
+	if msg.Envelope != nil {
+		entry.Subject = msg.Envelope.Subject
+	}
+	entry.From = sender(msg)
+	r.Entries = append(r.Entries, entry)
+
+	stats := r.folder(folder)
+	stats.Matched++
+	stats.Bytes += uint64(msg.Size)
+}
+
+// addProcessed counts an email read from folder, matched or not.
+func (r *Report) addProcessed(folder string, msg *imap.Message) {
+	r.Processed++
+	r.folder(folder).Processed++
+	r.addSender(msg)
+}
+
+// resetFolder drops the entries and totals of folder, so it can be processed
+// again. Sender totals are kept, they are only an estimate of mailbox usage.
+func (r *Report) resetFolder(name string) {
+	entries := r.Entries[:0]
+	for _, entry := range r.Entries {
+		if entry.Folder != name {
+			entries = append(entries, entry)
+		}
+	}
+	r.Entries = entries
+
+	stats := r.folder(name)
+	r.Processed -= stats.Processed
+	*stats = FolderStats{Name: name}
+}
+
+func (r *Report) folder(name string) *FolderStats {
+	for _, stats := range r.Folders {
+		if stats.Name == name {
+			return stats
+		}
+	}
+	stats := &FolderStats{Name: name}
+	r.Folders = append(r.Folders, stats)
+	return stats
+}
+
+func (r *Report) addSender(msg *imap.Message) {
+	if r.Senders == nil {
+		r.Senders = make(map[string]*SenderStats)
+	}
+	from := sender(msg)
+	stats, ok := r.Senders[from]
+	if !ok {
+		stats = &SenderStats{Sender: from}
+		r.Senders[from] = stats
+	}
+	stats.Count++
+	stats.Bytes += uint64(msg.Size)
+}
+
+func sender(msg *imap.Message) string {
+	if msg.Envelope == nil || len(msg.Envelope.From) == 0 {
+		return ""
+	}
+	return msg.Envelope.From[0].MailboxName + "@" + msg.Envelope.From[0].HostName
+}
+
+// MatchedBytes is the total size of all matched emails.
+func (r *Report) MatchedBytes() uint64 {
+	var total uint64
+	for _, entry := range r.Entries {
+		total += uint64(entry.Size)
+	}
+	return total
+}
+
+// TopSenders returns the n senders taking the most space.
+func (r *Report) TopSenders(n int) []SenderStats {
+	top := make([]SenderStats, 0, len(r.Senders))
+	for _, stats := range r.Senders {
+		top = append(top, *stats)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Bytes != top[j].Bytes {
+			return top[i].Bytes > top[j].Bytes
+		}
+		return top[i].Sender < top[j].Sender
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// Operations groups the UIDs matched in folder by action and target.
+// Operations that only change flags come first and deletions last, so a
+// failure half way leaves as many emails recoverable as possible.
+func (r *Report) Operations(folder string) ([]Operation, map[Operation][]uint32) {
+	uids := make(map[Operation][]uint32)
+	for _, entry := range r.Entries {
+		if entry.Folder != folder {
+			continue
+		}
+		op := Operation{Action: entry.Action, Target: entry.Target}
+		uids[op] = append(uids[op], entry.UID)
+	}
+
+	ops := make([]Operation, 0, len(uids))
+	for op := range uids {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].Action != ops[j].Action {
+			return actionOrder[ops[i].Action] < actionOrder[ops[j].Action]
+		}
+		return ops[i].Target < ops[j].Target
+	})
+	return ops, uids
+}
+
+func (o Operation) String() string {
+	if o.Target != "" {
+		return string(o.Action) + " " + o.Target
+	}
+	return string(o.Action)
+}
+
+var actionOrder = map[rules.Action]int{
+	rules.ActionFlag:     0,
+	rules.ActionMarkRead: 1,
+	rules.ActionLabel:    2,
+	rules.ActionMove:     3,
+	rules.ActionDelete:   4,
+}
+
+func (r *Report) Print(w io.Writer) {
+	if r.DryRun {
+		fmt.Fprintln(w, "\n=== Dry run: nothing was changed ===")
+	} else {
+		fmt.Fprintln(w, "\n=== Cleanup report ===")
+	}
+	fmt.Fprintf(w, "Processed: %d, matched: %d (%s)\n", r.Processed, len(r.Entries), formatBytes(r.MatchedBytes()))
+	for _, entry := range r.Entries {
+		op := Operation{Action: entry.Action, Target: entry.Target}
+		fmt.Fprintf(w, "%s | UID %d | %s | %s | %s | %s | %s\n",
+			entry.Folder, entry.UID, entry.From, entry.Subject, formatBytes(uint64(entry.Size)), entry.Rule, op)
+	}
+}
+
+// PrintSummary prints the totals of every processed folder.
+func (r *Report) PrintSummary(w io.Writer) {
+	fmt.Fprintln(w, "\n=== Folders ===")
+	for _, stats := range r.Folders {
+		fmt.Fprintf(w, "%s: processed %d, matched %d (%s)\n",
+			stats.Name, stats.Processed, stats.Matched, formatBytes(stats.Bytes))
+	}
+	fmt.Fprintf(w, "Total: processed %d, matched %d (%s)\n",
+		r.Processed, len(r.Entries), formatBytes(r.MatchedBytes()))
+}
+
+// AccountResult is the outcome of one account of a multi-account run.
+type AccountResult struct {
+	Name string
+	// Report is nil when the account failed before processing any folder.
+	Report *Report
+	Err    error
+}
+
+// PrintAccountsSummary prints the totals of every account and of the whole
+// run.
+func PrintAccountsSummary(w io.Writer, results []AccountResult) {
+	fmt.Fprintln(w, "\n=== Accounts ===")
+	var processed, matched, failed int
+	var bytes uint64
+	for _, result := range results {
+		line := result.Name + ":"
+		if report := result.Report; report != nil {
+			processed += report.Processed
+			matched += len(report.Entries)
+			bytes += report.MatchedBytes()
+			line += fmt.Sprintf(" processed %d, matched %d (%s)",
+				report.Processed, len(report.Entries), formatBytes(report.MatchedBytes()))
+		}
+		if result.Err != nil {
+			failed++
+			line += fmt.Sprintf(" failed: %v", result.Err)
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintf(w, "Total: %d accounts, %d failed, processed %d, matched %d (%s)\n",
+		len(results), failed, processed, matched, formatBytes(bytes))
+}
+
+func (r *Report) PrintTopSenders(w io.Writer, n int) {
+	fmt.Fprintf(w, "\n=== Top %d senders by size ===\n", n)
+	for i, stats := range r.TopSenders(n) {
+		fmt.Fprintf(w, "%2d. %s: %s in %d emails\n", i+1, stats.Sender, formatBytes(stats.Bytes), stats.Count)
+	}
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
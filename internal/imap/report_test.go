@@ -0,0 +1,120 @@
+package imap
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"mail-cleaner/internal/rules"
+
+	"github.com/emersion/go-imap"
+)
+
+func newSizedMessage(mailbox, host string, size uint32) *imap.Message {
+	return &imap.Message{
+		Size: size,
+		Envelope: &imap.Envelope{
+			From: []*imap.Address{{MailboxName: mailbox, HostName: host}},
+		},
+	}
+}
+
+func TestReport_TopSenders(t *testing.T) {
+	report := &Report{}
+	report.addSender(newSizedMessage("big", "example.com", 5000))
+	report.addSender(newSizedMessage("small", "example.com", 100))
+	report.addSender(newSizedMessage("small", "example.com", 100))
+	report.addSender(newSizedMessage("medium", "example.com", 3000))
+
+	got := report.TopSenders(2)
+	if len(got) != 2 {
+		t.Fatalf("TopSenders(2) returned %d senders", len(got))
+	}
+	if got[0].Sender != "big@example.com" || got[0].Bytes != 5000 {
+		t.Errorf("TopSenders()[0] = %+v, want big@example.com with 5000 bytes", got[0])
+	}
+	if got[1].Sender != "medium@example.com" {
+		t.Errorf("TopSenders()[1] = %+v, want medium@example.com", got[1])
+	}
+	if report.Senders["small@example.com"].Count != 2 {
+		t.Errorf("small@example.com count = %d, want 2", report.Senders["small@example.com"].Count)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want string
+	}{
+		{n: 512, want: "512 B"},
+		{n: 1536, want: "1.5 KB"},
+		{n: 5 << 20, want: "5.0 MB"},
+		{n: 3 << 30, want: "3.0 GB"},
+	}
+
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestReport_Folders(t *testing.T) {
+	report := &Report{}
+	deleteDecision := rules.Decision{Action: rules.ActionDelete}
+
+	inbox := newSizedMessage("spam", "example.com", 1000)
+	inbox.Uid = 7
+	report.addProcessed("INBOX", inbox)
+	report.add("INBOX", inbox, deleteDecision)
+	report.addProcessed("INBOX", newSizedMessage("friend", "example.com", 50))
+
+	news := newSizedMessage("news", "example.com", 300)
+	news.Uid = 7
+	report.addProcessed("Newsletters", news)
+	report.add("Newsletters", news, rules.Decision{Action: rules.ActionMove, Target: "Trash"})
+
+	if len(report.Folders) != 2 || report.Folders[0].Name != "INBOX" || report.Folders[1].Name != "Newsletters" {
+		t.Fatalf("Folders = %+v, want INBOX then Newsletters", report.Folders)
+	}
+	if got := *report.Folders[0]; got != (FolderStats{Name: "INBOX", Processed: 2, Matched: 1, Bytes: 1000}) {
+		t.Errorf("INBOX stats = %+v", got)
+	}
+	if report.Processed != 3 {
+		t.Errorf("Processed = %d, want 3", report.Processed)
+	}
+
+	// the same UID in another folder is another email
+	ops, uids := report.Operations("INBOX")
+	if len(ops) != 1 || ops[0].Action != rules.ActionDelete || len(uids[ops[0]]) != 1 {
+		t.Errorf("Operations(INBOX) = %v %v, want one delete", ops, uids)
+	}
+	ops, _ = report.Operations("Newsletters")
+	if len(ops) != 1 || ops[0].Action != rules.ActionMove {
+		t.Errorf("Operations(Newsletters) = %v, want one move", ops)
+	}
+}
+
+func TestPrintAccountsSummary(t *testing.T) {
+	work := &Report{}
+	msg := newSizedMessage("spam", "example.com", 2048)
+	work.addProcessed("INBOX", msg)
+	work.add("INBOX", msg, rules.Decision{Action: rules.ActionDelete})
+	work.addProcessed("INBOX", newSizedMessage("boss", "example.com", 100))
+
+	var out strings.Builder
+	PrintAccountsSummary(&out, []AccountResult{
+		{Name: "work", Report: work},
+		{Name: "home", Err: errors.New("failed to login")},
+	})
+
+	want := `
+=== Accounts ===
+work: processed 2, matched 1 (2.0 KB)
+home: failed: failed to login
+Total: 2 accounts, 1 failed, processed 2, matched 1 (2.0 KB)
+`
+	if out.String() != want {
+		t.Errorf("PrintAccountsSummary() =\n%s\nwant\n%s", out.String(), want)
+	}
+}
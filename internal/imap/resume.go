@@ -0,0 +1,156 @@
+package imap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mail-cleaner/internal/rules"
+	"net"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+const (
+	// maxResumes bounds how often one step, like a folder, is resumed after
+	// the connection dropped, so a server that always drops on the same
+	// email doesn't keep the run going forever.
+	maxResumes = 5
+	// reconnectAttempts is how often a dropped connection is dialed again
+	// before giving up on the run.
+	reconnectAttempts = 5
+	// The wait before each reconnect attempt doubles from minReconnectDelay
+	// up to maxReconnectDelay.
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// folderProgress is what passes over a folder have completed. A pass resumed
+// after a reconnect skips it, so no email is evaluated or changed twice.
+type folderProgress struct {
+	started     bool
+	uidValidity uint32
+	// startedAt is when the first pass started.
+	startedAt time.Time
+	// modSeq is the HIGHESTMODSEQ read before the first pass.
+	modSeq uint64
+	// fetchedUID is the highest UID handled by the main fetch.
+	fetchedUID uint32
+	evaluated  map[uint32]bool
+	// pending are the evaluated emails still waiting for their body.
+	pending map[uint32]*pendingEmail
+	applied map[uint32]bool
+}
+
+func (p *folderProgress) start(uidValidity uint32) {
+	if p.started {
+		return
+	}
+	p.started = true
+	p.uidValidity = uidValidity
+	p.startedAt = time.Now()
+	p.evaluated = make(map[uint32]bool)
+	p.pending = make(map[uint32]*pendingEmail)
+	p.applied = make(map[uint32]bool)
+}
+
+// reset forgets everything but modSeq, for a folder whose UIDVALIDITY
+// changed.
+func (p *folderProgress) reset() {
+	*p = folderProgress{modSeq: p.modSeq}
+}
+
+// unapplied returns the uids whose operation has not been applied yet.
+func (p *folderProgress) unapplied(uids []uint32) []uint32 {
+	var rest []uint32
+	for _, uid := range uids {
+		if !p.applied[uid] {
+			rest = append(rest, uid)
+		}
+	}
+	return rest
+}
+
+func (p *folderProgress) markApplied(uids []uint32) {
+	for _, uid := range uids {
+		p.applied[uid] = true
+	}
+}
+
+// cleanFolder processes folder. When the connection drops it reconnects,
+// selects the folder again and resumes where the dropped pass stopped.
+func (c *Client) cleanFolder(ctx context.Context, folder string, rulesSet *rules.Rules, needs rules.Needs, report *Report) error {
+	progress := &folderProgress{}
+	return c.withReconnect(ctx, func() error {
+		return c.resumeFolder(folder, rulesSet, needs, report, progress)
+	})
+}
+
+// withReconnect runs fn, and runs it again after reconnecting when it failed
+// because the connection dropped.
+func (c *Client) withReconnect(ctx context.Context, fn func() error) error {
+	for resumes := 0; ; resumes++ {
+		err := fn()
+		if err == nil || !c.connectionLost(err) || resumes == maxResumes {
+			return err
+		}
+
+		fmt.Fprintf(c.out, "Connection lost: %v\n", err)
+		if err := c.reconnect(ctx, reconnectAttempts); err != nil {
+			return err
+		}
+	}
+}
+
+// connectionLost reports whether err failed the command because the
+// connection to the server is closed. A network error can reach the command
+// a moment before the client notices the connection is gone, so it waits up
+// to a second for that. Other errors, like a NO from the server, never wait.
+func (c *Client) connectionLost(err error) bool {
+	if c.client.State() == imap.LogoutState {
+		return true
+	}
+	select {
+	case <-c.client.LoggedOut():
+		return true
+	default:
+	}
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) && !errors.Is(err, io.EOF) {
+		return false
+	}
+
+	select {
+	case <-c.client.LoggedOut():
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+// reconnect dials and logs in again, waiting longer after every failed
+// attempt. It gives up after attempts tries, or only when ctx is cancelled if
+// attempts is 0.
+func (c *Client) reconnect(ctx context.Context, attempts int) error {
+	delay := minReconnectDelay
+	for attempt := 1; ; attempt++ {
+		fmt.Fprintf(c.out, "Reconnecting in %v...\n", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		err := c.Connect()
+		if err == nil {
+			return nil
+		}
+		if attempts > 0 && attempt >= attempts {
+			return fmt.Errorf("failed to reconnect after %d attempts: %w", attempts, err)
+		}
+		fmt.Fprintf(c.out, "Error reconnecting: %v\n", err)
+		delay = min(2*delay, maxReconnectDelay)
+	}
+}
@@ -0,0 +1,114 @@
+package imap
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"mail-cleaner/internal/config"
+	"mail-cleaner/internal/rules"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestResumeFolder_SkipsCompletedWork(t *testing.T) {
+	c := newTestClient(t, &config.Config{})
+	appendEmail(t, c, "INBOX", "promo@spam.com", "Sale")
+	appendEmail(t, c, "INBOX", "news@spam.com", "News")
+	set := rules.NewRules([]rules.Rule{flagRule(t, "spam.com")})
+
+	mbox, err := c.SelectFolder("INBOX")
+	if err != nil {
+		t.Fatalf("SelectFolder() error = %v", err)
+	}
+
+	// a first pass evaluated and flagged UIDs 6 and 7, then the connection
+	// dropped
+	report := &Report{}
+	progress := &folderProgress{}
+	progress.start(mbox.UidValidity)
+	progress.fetchedUID = 7
+	for _, uid := range []uint32{6, 7} {
+		progress.evaluated[uid] = true
+		report.addProcessed("INBOX", &imap.Message{Uid: uid})
+	}
+	report.add("INBOX", &imap.Message{Uid: 7}, rules.Decision{Action: rules.ActionFlag})
+	progress.markApplied([]uint32{7})
+
+	if err := c.resumeFolder("INBOX", set, set.Needs(), report, progress); err != nil {
+		t.Fatalf("resumeFolder() error = %v", err)
+	}
+	if got := matchedUIDs(report); len(got) != 2 || got[1] != 8 {
+		t.Errorf("matched %v, want [7 8]", got)
+	}
+	if report.Processed != 3 {
+		t.Errorf("Processed = %d, want 3", report.Processed)
+	}
+	if !progress.applied[8] {
+		t.Errorf("UID 8 was not flagged")
+	}
+}
+
+func TestReport_ResetFolder(t *testing.T) {
+	report := &Report{}
+	for _, folder := range []string{"INBOX", "Spam", "INBOX"} {
+		msg := &imap.Message{Uid: 1, Size: 10}
+		report.addProcessed(folder, msg)
+		report.add(folder, msg, rules.Decision{Action: rules.ActionDelete})
+	}
+
+	report.resetFolder("INBOX")
+	if len(report.Entries) != 1 || report.Entries[0].Folder != "Spam" {
+		t.Errorf("Entries = %v, want only Spam", report.Entries)
+	}
+	if report.Processed != 1 {
+		t.Errorf("Processed = %d, want 1", report.Processed)
+	}
+	if stats := report.folder("INBOX"); stats.Processed != 0 || stats.Matched != 0 || stats.Bytes != 0 {
+		t.Errorf("INBOX stats = %+v, want zero", stats)
+	}
+}
+
+func TestCleanEmails_Reconnects(t *testing.T) {
+	c := newTestClient(t, &config.Config{})
+	appendEmail(t, c, "INBOX", "promo@spam.com", "Sale")
+	set := rules.NewRules([]rules.Rule{flagRule(t, "spam.com")})
+
+	// the connection drops before the folder is selected
+	if err := c.client.Terminate(); err != nil {
+		t.Fatalf("Terminate() error = %v", err)
+	}
+
+	report, err := c.CleanEmails(set)
+	if err != nil {
+		t.Fatalf("CleanEmails() error = %v", err)
+	}
+	if got := matchedUIDs(report); len(got) != 1 || got[0] != 7 {
+		t.Errorf("CleanEmails() matched %v, want [7]", got)
+	}
+}
+
+func TestConnectionLost(t *testing.T) {
+	c := newTestClient(t, &config.Config{})
+
+	// the server answers NO, the connection is fine
+	_, err := c.SelectFolder("Missing")
+	if err == nil {
+		t.Fatalf("SelectFolder() expected error")
+	}
+	start := time.Now()
+	if c.connectionLost(err) {
+		t.Errorf("connectionLost() = true after %v", err)
+	}
+	if waited := time.Since(start); waited > 100*time.Millisecond {
+		t.Errorf("connectionLost() waited %v after a server error", waited)
+	}
+
+	if err := c.client.Terminate(); err != nil {
+		t.Fatalf("Terminate() error = %v", err)
+	}
+	<-c.client.LoggedOut()
+	if !c.connectionLost(errors.New("imap: connection closed")) {
+		t.Errorf("connectionLost() = false after the connection closed")
+	}
+}
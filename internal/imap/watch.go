@@ -0,0 +1,160 @@
+package imap
+
+import (
+	"context"
+	"fmt"
+	"mail-cleaner/internal/rules"
+	"mail-cleaner/internal/state"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// idleRestart re-issues IDLE before servers drop it, RFC 2177 allows them to
+// after 30 minutes and many do after 29.
+const idleRestart = 25 * time.Minute
+
+// pollInterval is the NOOP interval used on servers without IDLE.
+var pollInterval = time.Minute
+
+// Watch runs a first pass like CleanEmails, then keeps the connection open
+// and cleans new emails in INBOX as they arrive, until ctx is cancelled.
+// onReport is called after the first pass and after every later pass that
+// processed emails. Dropped connections are reconnected.
+func (c *Client) Watch(ctx context.Context, rulesSet *rules.Rules, onReport func(*Report)) error {
+	// later passes only process emails newer than the state, so it is kept
+	// in memory when there is no state file
+	if c.state == nil {
+		if c.config.StateFile == "" {
+			c.state = state.New()
+		} else {
+			var err error
+			if c.state, err = state.Load(c.config.StateFile); err != nil {
+				return err
+			}
+		}
+	}
+
+	report, err := c.CleanEmails(rulesSet)
+	if report != nil {
+		onReport(report)
+	}
+	if err != nil {
+		return err
+	}
+	// -full-rescan applies to the first pass only
+	c.config.FullRescan = false
+
+	// the client may send updates until it is logged out, so the channel is
+	// drained for as long as the process runs
+	updates := make(chan client.Update, 16)
+	changed := make(chan struct{}, 1)
+	go notifyMailbox(updates, changed)
+	c.updates = updates
+	c.client.Updates = updates
+
+	needs := rulesSet.Needs()
+	for {
+		err := c.watchInbox(ctx, rulesSet, needs, changed, onReport)
+		if ctx.Err() != nil {
+			return nil
+		}
+		fmt.Fprintf(c.out, "Watch interrupted: %v\n", err)
+		// keep trying for as long as the watch runs
+		if err := c.reconnect(ctx, 0); err != nil {
+			return nil
+		}
+	}
+}
+
+// watchInbox cleans INBOX and waits for new emails, until ctx is cancelled or
+// the connection fails.
+func (c *Client) watchInbox(ctx context.Context, rulesSet *rules.Rules, needs rules.Needs, changed <-chan struct{}, onReport func(*Report)) error {
+	for {
+		report := &Report{DryRun: c.config.DryRun}
+		if err := c.cleanFolder(ctx, defaultFolder, rulesSet, needs, report); err != nil {
+			return err
+		}
+		if report.Processed > 0 {
+			onReport(report)
+		}
+
+		// the pass saved the highest UID it covered. Only newer emails
+		// start another one: updates caused by the pass itself, like the
+		// EXISTS answering SELECT, don't, and a message count would miss
+		// an email arriving together with an expunge.
+		known := c.state.Get(c.account(), defaultFolder).LastUID
+		fmt.Fprintln(c.out, "Waiting for new emails...")
+		for {
+			if err := c.idle(ctx, changed); err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			arrived, err := c.arrivedAfter(known)
+			if err != nil {
+				return err
+			}
+			if arrived {
+				break
+			}
+		}
+	}
+}
+
+// arrivedAfter reports whether the selected folder has emails with a UID
+// above uid.
+func (c *Client) arrivedAfter(uid uint32) (bool, error) {
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(uid+1, 0)
+	uids, err := c.client.UidSearch(criteria)
+	if err != nil {
+		return false, fmt.Errorf("search failed: %w", err)
+	}
+	for _, found := range uids {
+		// "n:*" always returns the last email, even when older than n
+		if found > uid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// idle waits in IDLE until changed is signalled or ctx is cancelled. Servers
+// without IDLE are polled with NOOP instead.
+func (c *Client) idle(ctx context.Context, changed <-chan struct{}) error {
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- c.client.Idle(stop, &client.IdleOptions{
+			LogoutTimeout: idleRestart,
+			PollInterval:  pollInterval,
+		})
+	}()
+
+	select {
+	case <-changed:
+	case <-ctx.Done():
+	case err := <-done:
+		return err
+	}
+	close(stop)
+	return <-done
+}
+
+// notifyMailbox signals changed for every mailbox update, without blocking
+// when a signal is already waiting.
+func notifyMailbox(updates <-chan client.Update, changed chan<- struct{}) {
+	for update := range updates {
+		if _, ok := update.(*client.MailboxUpdate); !ok {
+			continue
+		}
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
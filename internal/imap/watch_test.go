@@ -0,0 +1,265 @@
+package imap
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"mail-cleaner/internal/config"
+	"mail-cleaner/internal/rules"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/server"
+)
+
+func TestWatch_FirstPass(t *testing.T) {
+	cfg := &config.Config{}
+	c := newTestClient(t, cfg)
+	appendEmail(t, c, "INBOX", "promo@spam.com", "Sale")
+	set := rules.NewRules([]rules.Rule{flagRule(t, "spam.com")})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var reports []*Report
+	err := c.Watch(ctx, set, func(report *Report) {
+		reports = append(reports, report)
+		cancel()
+	})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, want only the first pass", len(reports))
+	}
+	if got := matchedUIDs(reports[0]); len(got) != 1 || got[0] != 7 {
+		t.Errorf("first pass matched %v, want [7]", got)
+	}
+	if saved := c.state.Get(c.account(), "INBOX"); saved.LastUID != 7 {
+		t.Errorf("state LastUID = %d, want 7", saved.LastUID)
+	}
+}
+
+func TestWatch_NewEmailWhileIdling(t *testing.T) {
+	addr, waiting := startWatchServer(t, true)
+	c := connectTestClient(t, &config.Config{}, addr)
+	other := connectTestClient(t, &config.Config{}, addr)
+	appendEmail(t, c, "INBOX", "promo@spam.com", "Sale")
+
+	reports := watchReports(t, c, waiting, func() {
+		// the message count stays the same, only the UIDs tell. The
+		// memory server gives the UID of an expunged last email to the
+		// next one, so the older email goes.
+		if _, err := other.SelectFolder("INBOX"); err != nil {
+			t.Fatalf("SelectFolder() error = %v", err)
+		}
+		if err := other.MarkForDeletion([]uint32{6}); err != nil {
+			t.Fatalf("MarkForDeletion() error = %v", err)
+		}
+		if err := other.ExpungeMarked(); err != nil {
+			t.Fatalf("ExpungeMarked() error = %v", err)
+		}
+		appendEmail(t, other, "INBOX", "promo@spam.com", "Another sale")
+	})
+
+	if got := matchedUIDs(reports[1]); len(got) != 1 || got[0] != 8 {
+		t.Errorf("pass after the new email matched %v, want [8]", got)
+	}
+}
+
+func TestWatch_PollsWithoutIdle(t *testing.T) {
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = 10 * time.Millisecond
+
+	addr, waiting := startWatchServer(t, false)
+	c := connectTestClient(t, &config.Config{}, addr)
+	other := connectTestClient(t, &config.Config{}, addr)
+	appendEmail(t, c, "INBOX", "promo@spam.com", "Sale")
+
+	reports := watchReports(t, c, waiting, func() {
+		appendEmail(t, other, "INBOX", "promo@spam.com", "Another sale")
+	})
+
+	if got := matchedUIDs(reports[1]); len(got) != 1 || got[0] != 8 {
+		t.Errorf("pass after the new email matched %v, want [8]", got)
+	}
+}
+
+// watchReports runs Watch with c until it reported twice. arrive is called
+// once the first pass is done and Watch is waiting for new emails.
+func watchReports(t *testing.T, c *Client, waiting <-chan struct{}, arrive func()) []*Report {
+	t.Helper()
+	set := rules.NewRules([]rules.Rule{flagRule(t, "spam.com")})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	found := make(chan *Report, 4)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Watch(ctx, set, func(report *Report) { found <- report })
+	}()
+
+	// Watch has to stop before the cleanup logs out
+	timedOut := func(what string) {
+		<-done
+		t.Fatalf("timed out waiting for %s", what)
+	}
+	var reports []*Report
+	next := func(what string) {
+		select {
+		case report := <-found:
+			reports = append(reports, report)
+		case <-ctx.Done():
+			timedOut(what)
+		}
+	}
+	next("the first pass")
+	select {
+	case <-waiting:
+	case <-ctx.Done():
+		timedOut("Watch to wait for new emails")
+	}
+	arrive()
+	next("a pass over the new email")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	return reports
+}
+
+// startWatchServer starts a test server that tells idling clients about
+// appended emails when idle is set. Otherwise it doesn't offer IDLE and
+// answers NOOP with the message count, like servers without push. waiting is
+// signalled when a client starts IDLE or polls.
+func startWatchServer(t *testing.T, idle bool) (net.Addr, <-chan struct{}) {
+	t.Helper()
+	waiting := make(chan struct{}, 1)
+	addr := startTestServer(t, func(srv *server.Server) {
+		srv.Enable(&watchExtension{
+			noIdle:  !idle,
+			waiting: waiting,
+			idlers:  make(map[server.Conn]bool),
+		})
+	})
+	return addr, waiting
+}
+
+type watchExtension struct {
+	noIdle  bool
+	waiting chan<- struct{}
+
+	mu     sync.Mutex
+	idlers map[server.Conn]bool
+}
+
+func (e *watchExtension) Capabilities(server.Conn) []string {
+	return nil
+}
+
+func (e *watchExtension) Command(name string) server.HandlerFactory {
+	switch name {
+	case "APPEND":
+		return func() server.Handler { return &watchAppend{ext: e} }
+	case "NOOP":
+		return func() server.Handler { return &watchNoop{ext: e} }
+	}
+	return nil
+}
+
+func (e *watchExtension) NewConn(conn server.Conn) server.Conn {
+	return &watchConn{Conn: conn, ext: e}
+}
+
+func (e *watchExtension) signal() {
+	select {
+	case e.waiting <- struct{}{}:
+	default:
+	}
+}
+
+// watchConn hides IDLE when noIdle is set and keeps track of the clients
+// that started IDLE.
+type watchConn struct {
+	server.Conn
+	ext *watchExtension
+}
+
+func (c *watchConn) Capabilities() []string {
+	var caps []string
+	for _, capability := range c.Conn.Capabilities() {
+		if capability != "IDLE" || !c.ext.noIdle {
+			caps = append(caps, capability)
+		}
+	}
+	return caps
+}
+
+func (c *watchConn) WriteResp(res imap.WriterTo) error {
+	if cont, ok := res.(*imap.ContinuationReq); ok && cont.Info == "idling" {
+		c.ext.mu.Lock()
+		c.ext.idlers[c] = true
+		c.ext.mu.Unlock()
+		c.ext.signal()
+	}
+	return c.Conn.WriteResp(res)
+}
+
+// watchAppend sends the new message count to the clients that idled.
+type watchAppend struct {
+	server.Append
+	ext *watchExtension
+}
+
+func (cmd *watchAppend) Handle(conn server.Conn) error {
+	if err := cmd.Append.Handle(conn); err != nil {
+		return err
+	}
+	exists, err := messageCount(conn, cmd.Mailbox)
+	if err != nil {
+		return err
+	}
+	cmd.ext.mu.Lock()
+	defer cmd.ext.mu.Unlock()
+	for idler := range cmd.ext.idlers {
+		if err := idler.WriteResp(exists); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchNoop answers with the message count of the selected folder.
+type watchNoop struct {
+	server.Noop
+	ext *watchExtension
+}
+
+func (cmd *watchNoop) Handle(conn server.Conn) error {
+	if conn.Context().Mailbox == nil {
+		return nil
+	}
+	cmd.ext.signal()
+	exists, err := messageCount(conn, conn.Context().Mailbox.Name())
+	if err != nil {
+		return err
+	}
+	return conn.WriteResp(exists)
+}
+
+// messageCount returns the EXISTS response of folder.
+func messageCount(conn server.Conn, folder string) (imap.WriterTo, error) {
+	mbox, err := conn.Context().User.GetMailbox(folder)
+	if err != nil {
+		return nil, err
+	}
+	status, err := mbox.Status([]imap.StatusItem{imap.StatusMessages})
+	if err != nil {
+		return nil, err
+	}
+	return &responses.Select{Mailbox: status}, nil
+}
@@ -0,0 +1,140 @@
+// Package mailtext extracts readable text from raw MIME messages.
+package mailtext
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// maxDepth limits nested multiparts, real mail rarely goes past 3.
+const maxDepth = 10
+
+// WordDecoder decodes RFC 2047 encoded words in any charset known to
+// x/net/html/charset, e.g. windows-1251 and koi8-u.
+var WordDecoder = &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+// Extract returns the text of a message given its top-level header and body.
+// text/plain parts are decoded from quoted-printable or base64 and converted
+// to UTF-8, text/html parts are also stripped of tags. Attachments are
+// skipped. Broken or truncated parts are decoded as far as possible.
+func Extract(header, body []byte) string {
+	raw := make([]byte, 0, len(header)+len(body)+2)
+	raw = append(raw, bytes.TrimRight(header, "\r\n")...)
+	raw = append(raw, "\r\n\r\n"...)
+	raw = append(raw, body...)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		// no usable header, treat the body as plain text
+		return string(body)
+	}
+
+	var b strings.Builder
+	extractPart(&b, msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"),
+		msg.Header.Get("Content-Disposition"), msg.Body, 0)
+	return b.String()
+}
+
+func extractPart(b *strings.Builder, contentType, encoding, disposition string, body io.Reader, depth int) {
+	if depth > maxDepth {
+		return
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if d, _, err := mime.ParseMediaType(disposition); err == nil && d == "attachment" {
+		return
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err != nil {
+				return
+			}
+			extractPart(b, part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"),
+				part.Header.Get("Content-Disposition"), part, depth+1)
+		}
+	}
+
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return
+	}
+
+	decoded, _ := io.ReadAll(toUTF8(decodeTransfer(body, encoding), params["charset"]))
+	if mediaType == "text/html" {
+		decoded = []byte(StripHTML(string(decoded)))
+	}
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	b.Write(decoded)
+}
+
+func decodeTransfer(body io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	case "base64":
+		// the decoder skips line breaks itself
+		return base64.NewDecoder(base64.StdEncoding, body)
+	default:
+		return body
+	}
+}
+
+func toUTF8(body io.Reader, charsetLabel string) io.Reader {
+	if charsetLabel == "" {
+		return body
+	}
+	reader, err := charset.NewReaderLabel(charsetLabel, body)
+	if err != nil {
+		return body
+	}
+	return reader
+}
+
+// StripHTML returns the visible text of an HTML document.
+func StripHTML(document string) string {
+	var b strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(document))
+	skip := 0
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(b.String())
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			if tag := string(name); tag == "script" || tag == "style" {
+				skip++
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if tag := string(name); (tag == "script" || tag == "style") && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			if skip == 0 {
+				text := strings.TrimSpace(string(tokenizer.Text()))
+				if text != "" {
+					if b.Len() > 0 {
+						b.WriteString(" ")
+					}
+					b.WriteString(text)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package mailtext
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func mustEncode(t *testing.T, enc *charmap.Charmap, s string) string {
+	t.Helper()
+	out, err := enc.NewEncoder().String(s)
+	if err != nil {
+		t.Fatalf("failed to encode %q: %v", s, err)
+	}
+	return out
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		want   []string
+		absent []string
+	}{
+		{
+			name:   "plain text without header",
+			header: "",
+			body:   "Click here to unsubscribe",
+			want:   []string{"unsubscribe"},
+		},
+		{
+			name:   "quoted-printable",
+			header: "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n",
+			body:   "Limited time =\r\noffer =E2=80=94 50% off",
+			want:   []string{"Limited time offer — 50% off"},
+		},
+		{
+			name:   "base64 with line breaks",
+			header: "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n",
+			body:   "TGltaXRlZCB0aW1l\r\nIG9mZmVy\r\n",
+			want:   []string{"Limited time offer"},
+		},
+		{
+			name:   "html is stripped",
+			header: "Content-Type: text/html; charset=utf-8\r\n",
+			body:   "<html><style>p{color:red}</style><body><p>Don&#39;t miss</p><a href=\"x\">Unsubscribe</a></body></html>",
+			want:   []string{"Don't miss Unsubscribe"},
+			absent: []string{"color", "href", "<p>"},
+		},
+		{
+			name: "multipart alternative with attachment",
+			header: "MIME-Version: 1.0\r\n" +
+				"Content-Type: multipart/mixed; boundary=\"outer\"\r\n",
+			body: "--outer\r\n" +
+				"Content-Type: multipart/alternative; boundary=\"inner\"\r\n\r\n" +
+				"--inner\r\nContent-Type: text/plain\r\n\r\nplain part\r\n" +
+				"--inner\r\nContent-Type: text/html\r\n\r\n<b>html part</b>\r\n" +
+				"--inner--\r\n" +
+				"--outer\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=\"notes.txt\"\r\n\r\nattached secret\r\n" +
+				"--outer--\r\n",
+			want:   []string{"plain part", "html part"},
+			absent: []string{"attached secret"},
+		},
+		{
+			name:   "truncated multipart keeps what was read",
+			header: "Content-Type: multipart/alternative; boundary=b\r\n",
+			body:   "--b\r\nContent-Type: text/plain\r\n\r\nfirst part\r\n--b\r\nContent-Type: text/html\r\n\r\n<p>cut",
+			want:   []string{"first part"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Extract([]byte(tt.header), []byte(tt.body))
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("Extract() = %q, want it to contain %q", got, want)
+				}
+			}
+			for _, absent := range tt.absent {
+				if strings.Contains(got, absent) {
+					t.Errorf("Extract() = %q, should not contain %q", got, absent)
+				}
+			}
+		})
+	}
+}
+
+func TestExtract_Charsets(t *testing.T) {
+	tests := []struct {
+		name    string
+		charset string
+		enc     *charmap.Charmap
+		text    string
+	}{
+		{name: "windows-1251", charset: "windows-1251", enc: charmap.Windows1251, text: "Знижки тільки сьогодні"},
+		{name: "koi8-u", charset: "koi8-u", enc: charmap.KOI8U, text: "Відписатися від розсилки"},
+		{name: "koi8-r", charset: "KOI8-R", enc: charmap.KOI8R, text: "Отписаться от рассылки"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := "Content-Type: text/plain; charset=" + tt.charset + "\r\n"
+			got := Extract([]byte(header), []byte(mustEncode(t, tt.enc, tt.text)))
+			if got != tt.text {
+				t.Errorf("Extract() = %q, want %q", got, tt.text)
+			}
+		})
+	}
+}
+
+func TestWordDecoder_Charsets(t *testing.T) {
+	want := "Тестовий лист"
+	encoded := "=?koi8-u?B?" + base64.StdEncoding.EncodeToString([]byte(mustEncode(t, charmap.KOI8U, want))) + "?="
+
+	got, err := WordDecoder.DecodeHeader(encoded)
+	if err != nil {
+		t.Fatalf("DecodeHeader() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("DecodeHeader() = %q, want %q", got, want)
+	}
+}
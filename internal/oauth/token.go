@@ -0,0 +1,144 @@
+// Package oauth gets OAuth2 access tokens for IMAP XOAUTH2 and OAUTHBEARER
+// authentication.
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource returns an access token valid for at least a few minutes.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is an access token set in the configuration. It stops working
+// when it expires.
+type StaticToken string
+
+func (t StaticToken) Token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+// expiryMargin is how long before its expiry a token is refreshed, so it
+// doesn't expire between Token and the login.
+const expiryMargin = time.Minute
+
+// RefreshSource exchanges a refresh token for access tokens at a token
+// endpoint (RFC 6749 section 6), like Google's or Microsoft's. Access tokens
+// are reused until shortly before they expire.
+type RefreshSource struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+
+	mu           sync.Mutex
+	refreshToken string
+	token        string
+	expiry       time.Time
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+func NewRefreshSource(endpoint, clientID, clientSecret, refreshToken string) *RefreshSource {
+	return &RefreshSource{
+		endpoint:     endpoint,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		refreshToken: refreshToken,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+func (s *RefreshSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Add(expiryMargin).Before(s.expiry) {
+		return s.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.refreshToken},
+		"client_id":     {s.clientID},
+	}
+	if s.clientSecret != "" {
+		form.Set("client_secret", s.clientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode token response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		return "", fmt.Errorf("token endpoint returned status %d: %s %s", resp.StatusCode, result.Error, result.Description)
+	}
+	if result.AccessToken == "" {
+		return "", fmt.Errorf("token endpoint returned no access token")
+	}
+
+	s.token = result.AccessToken
+	s.expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	// some providers rotate refresh tokens
+	if result.RefreshToken != "" {
+		s.refreshToken = result.RefreshToken
+	}
+	return s.token, nil
+}
+
+// CommandSource runs a shell command printing an access token, like a
+// password manager or a provider's CLI. It runs on every Token call, the
+// command is expected to cache tokens itself.
+type CommandSource struct {
+	command string
+}
+
+func NewCommandSource(command string) *CommandSource {
+	return &CommandSource{command: command}
+}
+
+func (s *CommandSource) Token(ctx context.Context) (string, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", s.command)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("token command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "", fmt.Errorf("token command printed no token")
+	}
+	return token, nil
+}
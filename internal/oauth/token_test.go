@@ -0,0 +1,68 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRefreshSource(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("ParseForm() error = %v", err)
+		}
+		if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("client_id") != "app" || r.Form.Get("client_secret") != "secret" {
+			t.Errorf("unexpected form %v", r.Form)
+		}
+		switch r.Form.Get("refresh_token") {
+		case "refresh-1":
+			// a token expiring within the margin, refreshed on the next call
+			fmt.Fprint(w, `{"access_token": "access-1", "expires_in": 30, "refresh_token": "refresh-2"}`)
+		case "refresh-2":
+			fmt.Fprint(w, `{"access_token": "access-2", "expires_in": 3600}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant", "error_description": "Token has been revoked"}`)
+		}
+	}))
+	defer srv.Close()
+
+	source := NewRefreshSource(srv.URL, "app", "secret", "refresh-1")
+	for _, want := range []string{"access-1", "access-2", "access-2"} {
+		token, err := source.Token(context.Background())
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if token != want {
+			t.Errorf("Token() = %q, want %q", token, want)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("token endpoint called %d times, want 2", requests)
+	}
+
+	revoked := NewRefreshSource(srv.URL, "app", "secret", "revoked")
+	if _, err := revoked.Token(context.Background()); err == nil {
+		t.Errorf("Token() with a revoked refresh token succeeded")
+	}
+}
+
+func TestCommandSource(t *testing.T) {
+	token, err := NewCommandSource("echo '  access-token  '").Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if token != "access-token" {
+		t.Errorf("Token() = %q, want %q", token, "access-token")
+	}
+
+	for _, command := range []string{"exit 1", "true"} {
+		if _, err := NewCommandSource(command).Token(context.Background()); err == nil {
+			t.Errorf("Token() with command %q succeeded", command)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package rules
+
+import (
+	"bytes"
+	"mail-cleaner/internal/mailtext"
+
+	"github.com/emersion/go-imap"
+)
+
+// Prematch is the result of evaluating a rule before the body is fetched.
+type Prematch int
+
+const (
+	NoMatch Prematch = iota
+	Match
+	// NeedBody means the rule can only decide once the body is fetched.
+	NeedBody
+)
+
+// Prematcher is implemented by rules that read the body, and by composites
+// containing them, so cheap children can rule out a match early.
+type Prematcher interface {
+	Prematch(msg *imap.Message) Prematch
+}
+
+// PrematchOf evaluates rule without the body. Rules that don't need the body
+// are evaluated normally.
+func PrematchOf(rule Rule, msg *imap.Message) Prematch {
+	if prematcher, ok := rule.(Prematcher); ok {
+		return prematcher.Prematch(msg)
+	}
+	if NeedsOf(rule).Body && !HasBody(msg) {
+		return NeedBody
+	}
+	if rule.ShouldDelete(msg) {
+		return Match
+	}
+	return NoMatch
+}
+
+// HasBody reports whether the message text was fetched.
+func HasBody(msg *imap.Message) bool {
+	for section := range msg.Body {
+		if section.Specifier == imap.TextSpecifier && len(section.Path) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// BodyText returns the decoded text of the message, see mailtext.Extract. It
+// is empty when the body was not fetched.
+func BodyText(msg *imap.Message) string {
+	var header, body []byte
+	for section, literal := range msg.Body {
+		if literal == nil || len(section.Path) != 0 {
+			continue
+		}
+		switch section.Specifier {
+		case imap.HeaderSpecifier:
+			header = append(header, bytes.TrimRight(literalBytes(literal), "\r\n")...)
+			header = append(header, "\r\n"...)
+		case imap.TextSpecifier:
+			body = literalBytes(literal)
+		}
+	}
+	if body == nil {
+		return ""
+	}
+	return mailtext.Extract(header, body)
+}
+
+// Pending marks where evaluation stopped because a rule needs the body.
+type Pending struct {
+	next int
+}
+
+// Precheck evaluates the rules without the message body. When a rule needs
+// the body to decide, evaluation stops and pending is returned; fetch the
+// body and call Resume to finish. Rules before that point are not evaluated
+// again.
+func (r *Rules) Precheck(msg *imap.Message) (Decision, bool, *Pending) {
+	if decision, ok := r.allowed(msg); ok {
+		return decision, true, nil
+	}
+
+	for i, rule := range r.rules {
+		if NeedsOf(rule).Body {
+			switch PrematchOf(rule, msg) {
+			case NoMatch:
+				continue
+			case NeedBody:
+				return Decision{}, false, &Pending{next: i}
+			}
+		}
+		if decision, ok := decide(rule, msg); ok {
+			return decision, true, nil
+		}
+	}
+	return Decision{}, false, nil
+}
+
+// Resume finishes an evaluation stopped by Precheck, once the body has been
+// added to msg.
+func (r *Rules) Resume(msg *imap.Message, pending *Pending) (Decision, bool) {
+	for _, rule := range r.rules[pending.next:] {
+		if decision, ok := decide(rule, msg); ok {
+			return decision, true
+		}
+	}
+	return Decision{}, false
+}
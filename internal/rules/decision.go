@@ -0,0 +1,117 @@
+package rules
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+type Action string
+
+const (
+	ActionDelete   Action = "delete"
+	ActionMove     Action = "move"
+	ActionFlag     Action = "flag"
+	ActionMarkRead Action = "mark_read"
+	ActionLabel    Action = "label"
+	ActionKeep     Action = "keep"
+)
+
+// ParseAction validates an action name from a rules file. An empty name
+// means delete, which is what every rule did before actions existed.
+func ParseAction(name string) (Action, error) {
+	switch action := Action(name); action {
+	case "":
+		return ActionDelete, nil
+	case ActionDelete, ActionMove, ActionFlag, ActionMarkRead, ActionLabel, ActionKeep:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown action: %s", name)
+	}
+}
+
+// NeedsTarget reports whether the action requires a folder or keyword.
+func (a Action) NeedsTarget() bool {
+	return a == ActionMove || a == ActionLabel
+}
+
+// Decision is what should happen to a matched email.
+type Decision struct {
+	Action Action
+	// Target is the destination folder for move and the keyword for label.
+	Target string
+	// Reason describes the rule that made the decision.
+	Reason string
+}
+
+// Decider is implemented by rules that choose their own action instead of
+// the default delete.
+type Decider interface {
+	Decide(msg *imap.Message) (Decision, bool)
+}
+
+type actionRule struct {
+	rule   Rule
+	action Action
+	target string
+}
+
+// WithAction wraps a rule so that emails it matches get action instead of
+// being deleted.
+func WithAction(rule Rule, action Action, target string) (Rule, error) {
+	if action.NeedsTarget() && target == "" {
+		return nil, fmt.Errorf("action %s requires a target", action)
+	}
+	return &actionRule{rule: rule, action: action, target: target}, nil
+}
+
+func (a *actionRule) ShouldDelete(msg *imap.Message) bool {
+	return a.action == ActionDelete && a.rule.ShouldDelete(msg)
+}
+
+func (a *actionRule) Decide(msg *imap.Message) (Decision, bool) {
+	if !a.rule.ShouldDelete(msg) {
+		return Decision{}, false
+	}
+	return Decision{Action: a.action, Target: a.target, Reason: Describe(a.rule)}, true
+}
+
+func (a *actionRule) Needs() Needs {
+	return NeedsOf(a.rule)
+}
+
+func (a *actionRule) Prematch(msg *imap.Message) Prematch {
+	return PrematchOf(a.rule, msg)
+}
+
+func (a *actionRule) SearchCriteria() (*imap.SearchCriteria, bool) {
+	return SearchOf(a.rule)
+}
+
+func (a *actionRule) AgedCriteria(lastRun time.Time) (*imap.SearchCriteria, bool) {
+	return AgedOf(a.rule, lastRun)
+}
+
+func (a *actionRule) Close() error {
+	if closer, ok := a.rule.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (a *actionRule) String() string {
+	if a.target != "" {
+		return fmt.Sprintf("%s -> %s %s", Describe(a.rule), a.action, a.target)
+	}
+	return fmt.Sprintf("%s -> %s", Describe(a.rule), a.action)
+}
+
+// Describe returns a human readable name for a rule.
+func Describe(rule Rule) string {
+	if s, ok := rule.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", rule)
+}
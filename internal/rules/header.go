@@ -0,0 +1,46 @@
+package rules
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mail-cleaner/internal/mailtext"
+	"net/textproto"
+
+	"github.com/emersion/go-imap"
+)
+
+// Header returns the header fields fetched for msg, with RFC 2047 encoded
+// words decoded. It is empty when no header section was fetched.
+func Header(msg *imap.Message) textproto.MIMEHeader {
+	header := make(textproto.MIMEHeader)
+	for section, literal := range msg.Body {
+		if section.Specifier != imap.HeaderSpecifier || literal == nil {
+			continue
+		}
+		fields, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(literalBytes(literal)))).ReadMIMEHeader()
+		if err != nil && len(fields) == 0 {
+			continue
+		}
+		for key, values := range fields {
+			for _, value := range values {
+				if decoded, err := mailtext.WordDecoder.DecodeHeader(value); err == nil {
+					value = decoded
+				}
+				header.Add(key, value)
+			}
+		}
+	}
+	return header
+}
+
+// literalBytes returns the content of a fetched literal. go-imap stores them
+// in a bytes.Buffer, which can be read this way without consuming it, so
+// several rules can look at the same section.
+func literalBytes(literal imap.Literal) []byte {
+	if buf, ok := literal.(interface{ Bytes() []byte }); ok {
+		return buf.Bytes()
+	}
+	data, _ := io.ReadAll(literal)
+	return data
+}
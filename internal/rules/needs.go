@@ -0,0 +1,60 @@
+package rules
+
+import (
+	"sort"
+	"strings"
+)
+
+// Needs describes message data a rule reads beyond the envelope, so the
+// client only fetches what the loaded rules actually use.
+type Needs struct {
+	// Headers are header field names fetched with BODY.PEEK[HEADER.FIELDS].
+	Headers []string
+	// Body is set by rules that read the message text. It is fetched only
+	// for emails the cheaper rules could not decide, see Rules.Precheck.
+	Body bool
+	// BodyStructure is set by rules that read the MIME structure of the
+	// email, e.g. its attachments.
+	BodyStructure bool
+}
+
+// Needer is implemented by rules that need extra message data fetched.
+type Needer interface {
+	Needs() Needs
+}
+
+// NeedsOf returns what rule needs, or nothing when it doesn't implement Needer.
+func NeedsOf(rule Rule) Needs {
+	if needer, ok := rule.(Needer); ok {
+		return needer.Needs()
+	}
+	return Needs{}
+}
+
+// Merge combines two sets of needs, removing duplicate headers.
+func (n Needs) Merge(other Needs) Needs {
+	seen := make(map[string]bool)
+	var headers []string
+	for _, h := range append(append([]string{}, n.Headers...), other.Headers...) {
+		key := strings.ToLower(h)
+		if !seen[key] {
+			seen[key] = true
+			headers = append(headers, h)
+		}
+	}
+	sort.Strings(headers)
+	return Needs{
+		Headers:       headers,
+		Body:          n.Body || other.Body,
+		BodyStructure: n.BodyStructure || other.BodyStructure,
+	}
+}
+
+// Needs returns what all rules in the set need, in any folder.
+func (r *Rules) Needs() Needs {
+	var needs Needs
+	for _, entry := range r.entries {
+		needs = needs.Merge(NeedsOf(entry.Rule))
+	}
+	return needs
+}
@@ -0,0 +1,150 @@
+package rule
+
+import (
+	"fmt"
+	"mail-cleaner/internal/rules"
+	"net/mail"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// AddressField selects an address list of the email.
+type AddressField string
+
+const (
+	FieldFrom        AddressField = "from"
+	FieldTo          AddressField = "to"
+	FieldCc          AddressField = "cc"
+	FieldBcc         AddressField = "bcc"
+	FieldReplyTo     AddressField = "reply_to"
+	FieldDeliveredTo AddressField = "delivered_to"
+	// FieldRecipients is a shorthand for to, cc, bcc and delivered_to.
+	FieldRecipients AddressField = "recipients"
+)
+
+// deliveredToHeader is not part of ENVELOPE and is fetched as a header.
+const deliveredToHeader = "Delivered-To"
+
+var defaultFields = []AddressField{FieldFrom}
+
+// parseFields reads the optional "field" option, a field name or a list of
+// them. Rules check the sender when it is missing.
+func parseFields(data map[string]any) ([]AddressField, error) {
+	names, err := parseStrings(data, "field")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	var fields []AddressField
+	for _, name := range names {
+		field := AddressField(strings.ToLower(strings.ReplaceAll(name, "-", "_")))
+		switch field {
+		case FieldFrom, FieldTo, FieldCc, FieldBcc, FieldReplyTo, FieldDeliveredTo:
+			fields = append(fields, field)
+		case FieldRecipients:
+			fields = append(fields, FieldTo, FieldCc, FieldBcc, FieldDeliveredTo)
+		default:
+			return nil, fmt.Errorf("unknown address field: %s", name)
+		}
+	}
+	return fields, nil
+}
+
+// addresses returns the addresses of msg in the given fields, the sender
+// when fields is empty.
+func addresses(msg *imap.Message, fields []AddressField) []*imap.Address {
+	if msg.Envelope == nil {
+		return nil
+	}
+	if len(fields) == 0 {
+		fields = defaultFields
+	}
+
+	var found []*imap.Address
+	for _, field := range fields {
+		switch field {
+		case FieldFrom:
+			found = append(found, msg.Envelope.From...)
+		case FieldTo:
+			found = append(found, msg.Envelope.To...)
+		case FieldCc:
+			found = append(found, msg.Envelope.Cc...)
+		case FieldBcc:
+			found = append(found, msg.Envelope.Bcc...)
+		case FieldReplyTo:
+			found = append(found, msg.Envelope.ReplyTo...)
+		case FieldDeliveredTo:
+			found = append(found, deliveredTo(msg)...)
+		}
+	}
+	return found
+}
+
+func deliveredTo(msg *imap.Message) []*imap.Address {
+	var found []*imap.Address
+	for _, value := range rules.Header(msg).Values(deliveredToHeader) {
+		list, err := mail.ParseAddressList(value)
+		if err != nil {
+			continue
+		}
+		for _, addr := range list {
+			mailbox, host, _ := strings.Cut(addr.Address, "@")
+			found = append(found, &imap.Address{PersonalName: addr.Name, MailboxName: mailbox, HostName: host})
+		}
+	}
+	return found
+}
+
+// fieldsNeeds returns the headers needed to read fields.
+func fieldsNeeds(fields []AddressField) rules.Needs {
+	for _, field := range fields {
+		if field == FieldDeliveredTo {
+			return rules.Needs{Headers: []string{deliveredToHeader}}
+		}
+	}
+	return rules.Needs{}
+}
+
+// fieldsLabel describes fields in log lines and rule descriptions.
+func fieldsLabel(fields []AddressField) string {
+	if len(fields) == 0 {
+		fields = defaultFields
+	}
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = string(field)
+	}
+	return strings.Join(names, "/")
+}
+
+func isDefaultFields(fields []AddressField) bool {
+	return len(fields) == 0 || (len(fields) == 1 && fields[0] == FieldFrom)
+}
+
+// fieldHeaders are the header names searched for each address field.
+var fieldHeaders = map[AddressField]string{
+	FieldFrom:        "From",
+	FieldTo:          "To",
+	FieldCc:          "Cc",
+	FieldBcc:         "Bcc",
+	FieldReplyTo:     "Reply-To",
+	FieldDeliveredTo: deliveredToHeader,
+}
+
+// fieldsCriteria searches for text in any of fields, the sender when empty.
+func fieldsCriteria(fields []AddressField, text string) *imap.SearchCriteria {
+	if len(fields) == 0 {
+		fields = defaultFields
+	}
+	var any []*imap.SearchCriteria
+	for _, field := range fields {
+		criteria := imap.NewSearchCriteria()
+		criteria.Header.Add(fieldHeaders[field], text)
+		any = append(any, criteria)
+	}
+	return rules.OrCriteria(any...)
+}
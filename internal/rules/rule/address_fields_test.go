@@ -0,0 +1,121 @@
+package rule
+
+import (
+	"bytes"
+	"testing"
+
+	"mail-cleaner/internal/rules"
+
+	"github.com/emersion/go-imap"
+)
+
+func newRecipientMessage() *imap.Message {
+	msg := &imap.Message{
+		Envelope: &imap.Envelope{
+			From:    []*imap.Address{{MailboxName: "ceo", HostName: "company.com"}},
+			To:      []*imap.Address{{MailboxName: "all-staff", HostName: "company.com"}},
+			Cc:      []*imap.Address{{MailboxName: "board", HostName: "partner.org"}},
+			ReplyTo: []*imap.Address{{MailboxName: "noreply", HostName: "mailer.net"}},
+			Subject: "Friday drinks",
+		},
+		Body: make(map[*imap.BodySectionName]imap.Literal),
+	}
+	msg.Body[&imap.BodySectionName{
+		BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier, Fields: []string{"Delivered-To"}},
+	}] = bytes.NewBufferString("Delivered-To: me+lists@example.com\r\n\r\n")
+	return msg
+}
+
+func TestAddressRule_Fields(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		fields  []AddressField
+		want    bool
+	}{
+		{name: "default is from", address: "ceo@company.com", want: true},
+		{name: "recipient not checked by default", address: "all-staff@company.com", want: false},
+		{name: "to", address: "all-staff@company.com", fields: []AddressField{FieldTo}, want: true},
+		{name: "cc", address: "board@partner.org", fields: []AddressField{FieldCc}, want: true},
+		{name: "reply-to", address: "noreply@mailer.net", fields: []AddressField{FieldReplyTo}, want: true},
+		{name: "delivered-to header", address: "me+lists@example.com", fields: []AddressField{FieldDeliveredTo}, want: true},
+		{name: "from not checked with fields", address: "ceo@company.com", fields: []AddressField{FieldTo, FieldCc}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewAddressRule(tt.address)
+			if err != nil {
+				t.Fatalf("NewAddressRule() error = %v", err)
+			}
+			rule.Fields = tt.fields
+			if got := rule.Matches(newRecipientMessage()); got != tt.want {
+				t.Errorf("AddressRule.Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateFromFile_RecipientFields(t *testing.T) {
+	path := writeRulesFile(t, `[
+		{"type": "address_rule", "address": "all-staff@company.com", "field": "to"},
+		{"type": "domain_rule", "domain": "partner.org", "match": "subdomain", "field": ["to", "cc"]},
+		{"type": "domain_rule", "domain": "example.com", "field": "recipients"},
+		{"type": "allow_address", "address": "me+lists@example.com", "field": "Delivered-To"}
+	]`)
+
+	got, err := CreateFromFile(path)
+	if err != nil {
+		t.Fatalf("CreateFromFile() error = %v", err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("CreateFromFile() returned %d rules, want 4", len(got))
+	}
+	for i, rule := range got[:3] {
+		if !rule.ShouldDelete(newRecipientMessage()) {
+			t.Errorf("rule %d (%s) did not match", i, rules.Describe(rule))
+		}
+	}
+
+	needs := rules.NewRules(got).Needs()
+	if len(needs.Headers) != 1 || needs.Headers[0] != "Delivered-To" {
+		t.Errorf("Needs().Headers = %v, want [Delivered-To]", needs.Headers)
+	}
+
+	assertInvalidRules(t, `{"type": "address_rule", "address": "x@example.com", "field": "subject"}`)
+}
+
+func TestBccOnlyRule_ShouldDelete(t *testing.T) {
+	rule, err := NewBccOnlyRule([]string{"me@example.com", "Alias@Example.com"})
+	if err != nil {
+		t.Fatalf("NewBccOnlyRule() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		to   []*imap.Address
+		cc   []*imap.Address
+		want bool
+	}{
+		{name: "in to", to: []*imap.Address{{MailboxName: "me", HostName: "example.com"}}, want: false},
+		{name: "alias in cc", cc: []*imap.Address{{MailboxName: "alias", HostName: "example.com"}}, want: false},
+		{name: "only bcc", to: []*imap.Address{{MailboxName: "someone", HostName: "else.com"}}, want: true},
+		{name: "undisclosed recipients", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &imap.Message{Envelope: &imap.Envelope{To: tt.to, Cc: tt.cc}}
+			if got := rule.ShouldDelete(msg); got != tt.want {
+				t.Errorf("BccOnlyRule.ShouldDelete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if rule.ShouldDelete(&imap.Message{}) {
+		t.Errorf("BccOnlyRule.ShouldDelete() = true without an envelope")
+	}
+	if _, err := NewBccOnlyRule(nil); err == nil {
+		t.Errorf("NewBccOnlyRule(nil) expected error")
+	}
+}
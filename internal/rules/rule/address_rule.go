@@ -4,13 +4,17 @@ import (
 	"errors"
 	"fmt"
 	"mail-cleaner/internal/rules"
-	"strings"
+	"regexp"
 
 	"github.com/emersion/go-imap"
 )
 
 type AddressRule struct {
 	Address string
+	Match   MatchMode
+	// Fields are the address lists checked, the sender when empty.
+	Fields []AddressField
+	re     *regexp.Regexp
 }
 
 func init() {
@@ -19,26 +23,51 @@ func init() {
 		if !ok {
 			return nil, fmt.Errorf("invalid or missing 'address' field")
 		}
-		return NewAddressRule(address)
+		mode, err := parseMatchMode(data, MatchExact)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := parseFields(data)
+		if err != nil {
+			return nil, err
+		}
+		rule, err := NewAddressRuleWithMatch(address, mode)
+		if err != nil {
+			return nil, err
+		}
+		rule.Fields = fields
+		return rule, nil
 	})
 }
 
 func NewAddressRule(address string) (*AddressRule, error) {
+	return NewAddressRuleWithMatch(address, MatchExact)
+}
+
+func NewAddressRuleWithMatch(address string, mode MatchMode) (*AddressRule, error) {
 	if address == "" {
 		return nil, errors.New("address cannot be empty")
 	}
+	re, err := compilePattern(mode, address)
+	if err != nil {
+		return nil, err
+	}
 	return &AddressRule{
 		Address: address,
+		Match:   mode,
+		re:      re,
 	}, nil
 }
 
 func (r *AddressRule) ShouldDelete(msg *imap.Message) bool {
-	if msg.Envelope == nil {
-		return false
-	}
-	for _, addr := range msg.Envelope.From {
+	return r.Matches(msg)
+}
+
+// Matches reports whether the rule's address is in one of the rule's fields,
+// by default whether the email is from it.
+func (r *AddressRule) Matches(msg *imap.Message) bool {
+	for _, addr := range addresses(msg, r.Fields) {
 		if r.apply(addr.MailboxName+"@"+addr.HostName, r.Address) {
-			fmt.Printf("Deleting email from: %s\n", r.Address)
 			return true
 		}
 	}
@@ -46,5 +75,28 @@ func (r *AddressRule) ShouldDelete(msg *imap.Message) bool {
 }
 
 func (r *AddressRule) apply(emailAddress string, ruleAddress string) bool {
-	return strings.EqualFold(emailAddress, ruleAddress)
+	return matchValue(r.Match.or(MatchExact), r.re, emailAddress, ruleAddress)
+}
+
+func (r *AddressRule) Needs() rules.Needs {
+	return fieldsNeeds(r.Fields)
+}
+
+func (r *AddressRule) String() string {
+	extra := ""
+	if r.Match.or(MatchExact) != MatchExact {
+		extra += fmt.Sprintf(", Match: %s", r.Match)
+	}
+	if !isDefaultFields(r.Fields) {
+		extra += fmt.Sprintf(", Field: %s", fieldsLabel(r.Fields))
+	}
+	return fmt.Sprintf("AddressRule{Address: %s%s}", r.Address, extra)
+}
+
+func (r *AddressRule) SearchCriteria() (*imap.SearchCriteria, bool) {
+	text, ok := searchText(r.Match.or(MatchExact), r.Address)
+	if !ok {
+		return nil, false
+	}
+	return fieldsCriteria(r.Fields, text), true
 }
@@ -2,6 +2,7 @@ package rule
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"mail-cleaner/internal/ai/ollama"
@@ -16,12 +17,17 @@ type Classifier interface {
 
 type AIRule struct {
 	Enabled            bool   `json:"enabled"`
-	Action             string `json:"action"` // "log" или "delete"
+	Action             string `json:"action"` // "log" или любое действие из rules.Action
+	Target             string `json:"target"`
 	prompt             string
 	classifier         Classifier
 	excluded_domains   []string
 	excluded_addresses []string
 	logFile            *os.File
+	// logErr is why the log file couldn't be opened, reported with the
+	// first classification printed instead.
+	logErr error
+	out    io.Writer
 }
 
 func init() {
@@ -35,8 +41,15 @@ func init() {
 		if !ok {
 			action = "log" // по умолчанию только логирование
 		}
-		if action != "log" && action != "delete" {
-			return nil, fmt.Errorf("action must be 'log' or 'delete', got: %s", action)
+		target, _ := config["target"].(string)
+		if action != "log" {
+			parsed, err := rules.ParseAction(action)
+			if err != nil {
+				return nil, fmt.Errorf("action must be 'log' or a rule action, got: %s", action)
+			}
+			if parsed.NeedsTarget() && target == "" {
+				return nil, fmt.Errorf("action %s requires a target", action)
+			}
 		}
 
 		prompt, ok := config["prompt"].(string)
@@ -70,7 +83,12 @@ func init() {
 			}
 		}
 
-		return NewAIRule(enabled, action, prompt, client, excludedDomains, excludedAddresses)
+		rule, err := NewAIRule(enabled, action, prompt, client, excludedDomains, excludedAddresses)
+		if err != nil {
+			return nil, err
+		}
+		rule.Target = target
+		return rule, nil
 	})
 }
 
@@ -80,9 +98,6 @@ func NewAIRule(enabled bool, action string, prompt string, classifier Classifier
 
 	if enabled {
 		logFile, err = os.OpenFile("spam_classification.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			fmt.Printf("Warning: failed to open log file: %v\n", err)
-		}
 	}
 
 	return &AIRule{
@@ -93,16 +108,23 @@ func NewAIRule(enabled bool, action string, prompt string, classifier Classifier
 		excluded_domains:   excludedDomains,
 		excluded_addresses: excludedAddresses,
 		logFile:            logFile,
+		logErr:             err,
+		out:                os.Stdout,
 	}, nil
 }
 
 func (ar *AIRule) ShouldDelete(msg *imap.Message) bool {
+	decision, ok := ar.Decide(msg)
+	return ok && decision.Action == rules.ActionDelete
+}
+
+func (ar *AIRule) Decide(msg *imap.Message) (rules.Decision, bool) {
 	if !ar.Enabled {
-		return false
+		return rules.Decision{}, false
 	}
 
 	if msg.Envelope == nil || len(msg.Envelope.From) == 0 {
-		return false
+		return rules.Decision{}, false
 	}
 
 	for _, addr := range msg.Envelope.From {
@@ -133,11 +155,14 @@ func (ar *AIRule) ShouldDelete(msg *imap.Message) bool {
 		}
 
 		if ar.apply(emailAddress, subject) {
-			return true
+			return rules.Decision{
+				Action: rules.Action(ar.Action),
+				Target: ar.Target,
+				Reason: fmt.Sprintf("%s: %s classified as spam", ar, emailAddress),
+			}, true
 		}
 	}
-
-	return false
+	return rules.Decision{}, false
 }
 
 func (ar *AIRule) apply(emailAddress string, subject string) bool {
@@ -147,20 +172,15 @@ func (ar *AIRule) apply(emailAddress string, subject string) bool {
 
 	isSpam, err := ar.classifier.IsSpam(emailAddress, subject, ar.prompt)
 	if err != nil {
-		fmt.Printf("Error classifying email: %v\n", err)
+		fmt.Fprintf(ar.output(), "Error classifying email: %v\n", err)
 		return false
 	}
 
 	if isSpam {
-		message := fmt.Sprintf("Classified as spam: %s - %s\n", emailAddress, subject)
-		if ar.logFile != nil {
-			ar.logFile.WriteString(message)
-		} else {
-			fmt.Print(message)
-		}
+		ar.log(fmt.Sprintf("Classified as spam: %s - %s\n", emailAddress, subject))
 
-		// the main logic to decide whether to delete or not
-		if ar.Action == "delete" {
+		// "log" only records the classification, any other action is applied
+		if ar.Action != "log" {
 			return true
 		}
 	}
@@ -168,6 +188,32 @@ func (ar *AIRule) apply(emailAddress string, subject string) bool {
 	return false
 }
 
+// log writes message to the log file, or prints it when the file couldn't be
+// opened.
+func (ar *AIRule) log(message string) {
+	if ar.logFile != nil {
+		ar.logFile.WriteString(message)
+		return
+	}
+	if ar.logErr != nil {
+		fmt.Fprintf(ar.output(), "Warning: failed to open log file: %v\n", ar.logErr)
+		ar.logErr = nil
+	}
+	fmt.Fprint(ar.output(), message)
+}
+
+func (ar *AIRule) output() io.Writer {
+	if ar.out == nil {
+		return os.Stdout
+	}
+	return ar.out
+}
+
+// SetOutput sends what the rule prints to w instead of stdout.
+func (ar *AIRule) SetOutput(w io.Writer) {
+	ar.out = w
+}
+
 func (ar *AIRule) Close() error {
 	if ar.logFile != nil {
 		return ar.logFile.Close()
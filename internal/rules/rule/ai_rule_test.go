@@ -1,6 +1,7 @@
 package rule
 
 import (
+	"bytes"
 	"errors"
 	"testing"
 
@@ -277,3 +278,17 @@ func TestNewAIRule(t *testing.T) {
 		})
 	}
 }
+
+func TestAIRule_SetOutput(t *testing.T) {
+	var out bytes.Buffer
+	rule := &AIRule{Enabled: true, Action: "log", classifier: &mockClassifier{shouldReturnSpam: true}}
+	rule.SetOutput(&out)
+	rule.apply("spam@example.com", "Win")
+	rule.classifier = &mockClassifier{shouldReturnErr: true}
+	rule.apply("spam@example.com", "Win")
+
+	want := "Classified as spam: spam@example.com - Win\nError classifying email: mock error\n"
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
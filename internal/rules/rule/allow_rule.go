@@ -0,0 +1,63 @@
+package rule
+
+import (
+	"mail-cleaner/internal/rules"
+
+	"github.com/emersion/go-imap"
+)
+
+type matcher interface {
+	Matches(msg *imap.Message) bool
+}
+
+// AllowRule protects emails matched by its matcher from every other rule in
+// the set. It never deletes anything itself.
+type AllowRule struct {
+	matcher matcher
+}
+
+func init() {
+	RegisterRuleFactory("allow_address", func(data map[string]any) (rules.Rule, error) {
+		rule, err := factories["address_rule"](data)
+		if err != nil {
+			return nil, err
+		}
+		return NewAllowRule(rule.(*AddressRule)), nil
+	})
+
+	RegisterRuleFactory("allow_domain", func(data map[string]any) (rules.Rule, error) {
+		rule, err := factories["domain_rule"](data)
+		if err != nil {
+			return nil, err
+		}
+		return NewAllowRule(rule.(*DomainRule)), nil
+	})
+
+	RegisterRuleFactory("allow_subject", func(data map[string]any) (rules.Rule, error) {
+		rule, err := factories["theme_rule"](data)
+		if err != nil {
+			return nil, err
+		}
+		return NewAllowRule(rule.(*ThemeRule)), nil
+	})
+}
+
+func NewAllowRule(m matcher) *AllowRule {
+	return &AllowRule{matcher: m}
+}
+
+func (r *AllowRule) ShouldDelete(msg *imap.Message) bool {
+	return false
+}
+
+func (r *AllowRule) Allows(msg *imap.Message) bool {
+	return r.matcher.Matches(msg)
+}
+
+func (r *AllowRule) Needs() rules.Needs {
+	return rules.NeedsOf(r.matcher.(rules.Rule))
+}
+
+func (r *AllowRule) String() string {
+	return "Allow" + rules.Describe(r.matcher.(rules.Rule))
+}
@@ -0,0 +1,68 @@
+package rule
+
+import (
+	"testing"
+
+	"mail-cleaner/internal/rules"
+)
+
+func TestAllowRules_VetoDeletion(t *testing.T) {
+	// allow rules come last in the file but still take precedence
+	path := writeRulesFile(t, `[
+		{"type": "domain_rule", "domain": "marketing"},
+		{"type": "theme_rule", "text": "offer"},
+		{"type": "allow_address", "address": "marketing@mycompany.com"},
+		{"type": "allow_domain", "domain": "partner.com"},
+		{"type": "allow_subject", "text": "contract"}
+	]`)
+
+	got, err := CreateFromFile(path)
+	if err != nil {
+		t.Fatalf("CreateFromFile() error = %v", err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("CreateFromFile() returned %d rules, want 5", len(got))
+	}
+	set := rules.NewRules(got)
+
+	tests := []struct {
+		name       string
+		mailbox    string
+		host       string
+		subject    string
+		wantAction rules.Action
+	}{
+		{name: "allowed address", mailbox: "marketing", host: "mycompany.com", subject: "Q3 plan", wantAction: rules.ActionKeep},
+		{name: "allowed domain", mailbox: "sales", host: "partner.com", subject: "Special offer", wantAction: rules.ActionKeep},
+		{name: "allowed subject", mailbox: "news", host: "marketing.com", subject: "Contract renewal", wantAction: rules.ActionKeep},
+		{name: "not allowed", mailbox: "news", host: "marketing.com", subject: "Weekly news", wantAction: rules.ActionDelete},
+		{name: "other sender at allowed company", mailbox: "promo", host: "marketing.mycompany.com", subject: "Hi", wantAction: rules.ActionDelete},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, ok := set.Decide(newTestMessage(tt.mailbox, tt.host, tt.subject))
+			if !ok {
+				t.Fatalf("Decide() did not match")
+			}
+			if decision.Action != tt.wantAction {
+				t.Errorf("Decide() action = %s (%s), want %s", decision.Action, decision.Reason, tt.wantAction)
+			}
+			if got := set.ShouldDelete(newTestMessage(tt.mailbox, tt.host, tt.subject)); got != (tt.wantAction == rules.ActionDelete) {
+				t.Errorf("ShouldDelete() = %v", got)
+			}
+		})
+	}
+}
+
+func TestAllowRule_ShouldDelete(t *testing.T) {
+	rule := NewAllowRule(&DomainRule{Domain: "example.com"})
+	msg := newTestMessage("news", "example.com", "Hello")
+
+	if rule.ShouldDelete(msg) {
+		t.Errorf("AllowRule.ShouldDelete() = true, allow rules never delete")
+	}
+	if !rule.Allows(msg) {
+		t.Errorf("AllowRule.Allows() = false, want true")
+	}
+}
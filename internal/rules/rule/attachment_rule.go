@@ -0,0 +1,236 @@
+package rule
+
+import (
+	"fmt"
+	"mail-cleaner/internal/rules"
+	"regexp"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// AttachmentRule matches emails by their attachments, read from BODYSTRUCTURE
+// so no body is downloaded. Only attachments matching Filenames and
+// ContentTypes (when set) are counted and summed.
+type AttachmentRule struct {
+	// Filenames are glob patterns, e.g. "*.exe" or "invoice*.pdf".
+	Filenames []string
+	// ContentTypes are glob patterns, e.g. "application/zip" or "image/*".
+	ContentTypes []string
+	// MinCount defaults to 1, MaxCount of zero is not checked.
+	MinCount int
+	MaxCount int
+	// LargerThan and SmallerThan bound the total size of the counted
+	// attachments. Sizes are as encoded in the email, so base64 attachments
+	// count about a third more than the decoded files.
+	LargerThan  uint32
+	SmallerThan uint32
+
+	filenames    []*regexp.Regexp
+	contentTypes []*regexp.Regexp
+}
+
+func init() {
+	RegisterRuleFactory("attachment_rule", func(data map[string]any) (rules.Rule, error) {
+		filenames, err := parseStrings(data, "filename")
+		if err != nil {
+			return nil, err
+		}
+		contentTypes, err := parseStrings(data, "content_type")
+		if err != nil {
+			return nil, err
+		}
+		minCount, err := parseCount(data, "min_count")
+		if err != nil {
+			return nil, err
+		}
+		maxCount, err := parseCount(data, "max_count")
+		if err != nil {
+			return nil, err
+		}
+		larger, err := parseSize(data, "larger_than")
+		if err != nil {
+			return nil, err
+		}
+		smaller, err := parseSize(data, "smaller_than")
+		if err != nil {
+			return nil, err
+		}
+		return NewAttachmentRule(&AttachmentRule{
+			Filenames:    filenames,
+			ContentTypes: contentTypes,
+			MinCount:     minCount,
+			MaxCount:     maxCount,
+			LargerThan:   larger,
+			SmallerThan:  smaller,
+		})
+	})
+}
+
+func NewAttachmentRule(r *AttachmentRule) (*AttachmentRule, error) {
+	if r.MinCount == 0 {
+		r.MinCount = 1
+	}
+	if r.MaxCount != 0 && r.MaxCount < r.MinCount {
+		return nil, fmt.Errorf("'max_count' must not be less than 'min_count'")
+	}
+	if r.SmallerThan != 0 && r.LargerThan >= r.SmallerThan {
+		return nil, fmt.Errorf("'larger_than' must be less than 'smaller_than'")
+	}
+
+	var err error
+	if r.filenames, err = compileGlobs(r.Filenames); err != nil {
+		return nil, err
+	}
+	if r.contentTypes, err = compileGlobs(r.ContentTypes); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *AttachmentRule) ShouldDelete(msg *imap.Message) bool {
+	if msg.BodyStructure == nil {
+		// body structure was not fetched
+		return false
+	}
+
+	count := 0
+	var total uint32
+	for _, part := range attachments(msg.BodyStructure) {
+		if !r.matches(part) {
+			continue
+		}
+		count++
+		total += part.Size
+	}
+
+	if count < r.MinCount {
+		return false
+	}
+	if r.MaxCount != 0 && count > r.MaxCount {
+		return false
+	}
+	if r.LargerThan != 0 && total <= r.LargerThan {
+		return false
+	}
+	if r.SmallerThan != 0 && total >= r.SmallerThan {
+		return false
+	}
+	return true
+}
+
+func (r *AttachmentRule) matches(part *imap.BodyStructure) bool {
+	if len(r.filenames) > 0 {
+		filename, _ := part.Filename()
+		if !matchAny(r.filenames, filename) {
+			return false
+		}
+	}
+	if len(r.contentTypes) > 0 {
+		contentType := part.MIMEType + "/" + part.MIMESubType
+		if !matchAny(r.contentTypes, contentType) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *AttachmentRule) Needs() rules.Needs {
+	return rules.Needs{BodyStructure: true}
+}
+
+func (r *AttachmentRule) String() string {
+	var parts []string
+	if len(r.Filenames) > 0 {
+		parts = append(parts, fmt.Sprintf("Filename: %s", strings.Join(r.Filenames, ", ")))
+	}
+	if len(r.ContentTypes) > 0 {
+		parts = append(parts, fmt.Sprintf("ContentType: %s", strings.Join(r.ContentTypes, ", ")))
+	}
+	if r.MinCount > 1 {
+		parts = append(parts, fmt.Sprintf("MinCount: %d", r.MinCount))
+	}
+	if r.MaxCount > 0 {
+		parts = append(parts, fmt.Sprintf("MaxCount: %d", r.MaxCount))
+	}
+	if r.LargerThan > 0 {
+		parts = append(parts, fmt.Sprintf("LargerThan: %d", r.LargerThan))
+	}
+	if r.SmallerThan > 0 {
+		parts = append(parts, fmt.Sprintf("SmallerThan: %d", r.SmallerThan))
+	}
+	return fmt.Sprintf("AttachmentRule{%s}", strings.Join(parts, ", "))
+}
+
+// attachments returns the leaf parts of a body structure that are attachments:
+// parts with an attachment disposition or a filename.
+func attachments(bs *imap.BodyStructure) []*imap.BodyStructure {
+	var found []*imap.BodyStructure
+	bs.Walk(func(path []int, part *imap.BodyStructure) bool {
+		if len(part.Parts) > 0 {
+			return true
+		}
+		filename, _ := part.Filename()
+		if strings.EqualFold(part.Disposition, "attachment") || filename != "" {
+			found = append(found, part)
+		}
+		return false
+	})
+	return found
+}
+
+func compileGlobs(globs []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(globs))
+	for _, glob := range globs {
+		re, err := compilePattern(MatchGlob, glob)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func matchAny(patterns []*regexp.Regexp, value string) bool {
+	for _, re := range patterns {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStrings reads an optional field given as a string or a list of strings.
+func parseStrings(data map[string]any, field string) ([]string, error) {
+	switch value := data[field].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{value}, nil
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			text, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid '%s' field, expected strings", field)
+			}
+			values = append(values, text)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("invalid '%s' field", field)
+	}
+}
+
+// parseCount reads an optional non-negative whole number.
+func parseCount(data map[string]any, field string) (int, error) {
+	raw, exists := data[field]
+	if !exists {
+		return 0, nil
+	}
+	count, ok := raw.(float64)
+	if !ok || count < 0 || count != float64(int(count)) {
+		return 0, fmt.Errorf("invalid '%s' field, expected a whole number", field)
+	}
+	return int(count), nil
+}
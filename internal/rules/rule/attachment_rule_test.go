@@ -0,0 +1,98 @@
+package rule
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+func newAttachmentMessage() *imap.Message {
+	return &imap.Message{
+		BodyStructure: &imap.BodyStructure{
+			MIMEType:    "multipart",
+			MIMESubType: "mixed",
+			Parts: []*imap.BodyStructure{
+				{
+					MIMEType:    "multipart",
+					MIMESubType: "alternative",
+					Parts: []*imap.BodyStructure{
+						{MIMEType: "text", MIMESubType: "plain", Size: 100},
+						{MIMEType: "text", MIMESubType: "html", Size: 300},
+					},
+				},
+				{
+					MIMEType:          "application",
+					MIMESubType:       "pdf",
+					Size:              4 << 20,
+					Disposition:       "attachment",
+					DispositionParams: map[string]string{"filename": "Invoice.PDF"},
+				},
+				{
+					MIMEType:    "application",
+					MIMESubType: "zip",
+					Params:      map[string]string{"name": "setup.zip"},
+					Size:        1 << 20,
+				},
+			},
+		},
+	}
+}
+
+func TestAttachmentRule_ShouldDelete(t *testing.T) {
+	tests := []struct {
+		name string
+		rule AttachmentRule
+		msg  *imap.Message
+		want bool
+	}{
+		{name: "any attachment", rule: AttachmentRule{}, msg: newAttachmentMessage(), want: true},
+		{name: "filename glob", rule: AttachmentRule{Filenames: []string{"*.exe", "*.zip"}}, msg: newAttachmentMessage(), want: true},
+		{name: "filename case-insensitive", rule: AttachmentRule{Filenames: []string{"invoice*.pdf"}}, msg: newAttachmentMessage(), want: true},
+		{name: "filename not attached", rule: AttachmentRule{Filenames: []string{"*.iso"}}, msg: newAttachmentMessage(), want: false},
+		{name: "content type", rule: AttachmentRule{ContentTypes: []string{"application/*"}, MinCount: 2}, msg: newAttachmentMessage(), want: true},
+		{name: "text parts are not attachments", rule: AttachmentRule{ContentTypes: []string{"text/*"}}, msg: newAttachmentMessage(), want: false},
+		{name: "max count", rule: AttachmentRule{MaxCount: 1}, msg: newAttachmentMessage(), want: false},
+		{name: "huge pdf", rule: AttachmentRule{ContentTypes: []string{"application/pdf"}, LargerThan: 3 << 20}, msg: newAttachmentMessage(), want: true},
+		{name: "total size", rule: AttachmentRule{LargerThan: 5 << 20}, msg: newAttachmentMessage(), want: false},
+		{name: "total size below", rule: AttachmentRule{SmallerThan: 6 << 20}, msg: newAttachmentMessage(), want: true},
+		{name: "no attachments", rule: AttachmentRule{}, msg: &imap.Message{BodyStructure: &imap.BodyStructure{MIMEType: "text", MIMESubType: "plain"}}, want: false},
+		{name: "body structure not fetched", rule: AttachmentRule{}, msg: &imap.Message{}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewAttachmentRule(&tt.rule)
+			if err != nil {
+				t.Fatalf("NewAttachmentRule() error = %v", err)
+			}
+			if got := rule.ShouldDelete(tt.msg); got != tt.want {
+				t.Errorf("AttachmentRule.ShouldDelete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateFromFile_AttachmentRule(t *testing.T) {
+	path := writeRulesFile(t, `[
+		{"type": "attachment_rule", "filename": ["*.exe", "*.zip", "*.iso"]},
+		{"type": "attachment_rule", "content_type": "application/pdf", "larger_than": "2MB"}
+	]`)
+
+	got, err := CreateFromFile(path)
+	if err != nil {
+		t.Fatalf("CreateFromFile() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("CreateFromFile() returned %d rules, want 2", len(got))
+	}
+	for i, rule := range got {
+		if !rule.ShouldDelete(newAttachmentMessage()) {
+			t.Errorf("rule %d did not match", i)
+		}
+	}
+
+	assertInvalidRules(t,
+		`{"type": "attachment_rule", "min_count": 3, "max_count": 1}`,
+		`{"type": "attachment_rule", "filename": 5}`,
+	)
+}
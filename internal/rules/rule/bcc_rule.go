@@ -0,0 +1,58 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"mail-cleaner/internal/rules"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// BccOnlyRule matches emails that reached the mailbox without any of its
+// addresses in To or Cc, i.e. the owner was only a Bcc recipient or the email
+// came through a list or alias. Spam is often sent this way.
+type BccOnlyRule struct {
+	// Addresses are the mailbox's own addresses, including aliases.
+	Addresses []string
+}
+
+func init() {
+	RegisterRuleFactory("bcc_only", func(data map[string]any) (rules.Rule, error) {
+		addresses, err := parseStrings(data, "addresses")
+		if err != nil {
+			return nil, err
+		}
+		return NewBccOnlyRule(addresses)
+	})
+}
+
+func NewBccOnlyRule(addresses []string) (*BccOnlyRule, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("bcc_only needs the mailbox 'addresses'")
+	}
+	for _, address := range addresses {
+		if !strings.Contains(address, "@") {
+			return nil, fmt.Errorf("invalid address: %s", address)
+		}
+	}
+	return &BccOnlyRule{Addresses: addresses}, nil
+}
+
+func (r *BccOnlyRule) ShouldDelete(msg *imap.Message) bool {
+	if msg.Envelope == nil {
+		return false
+	}
+	for _, addr := range addresses(msg, []AddressField{FieldTo, FieldCc}) {
+		for _, own := range r.Addresses {
+			if strings.EqualFold(addr.MailboxName+"@"+addr.HostName, own) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (r *BccOnlyRule) String() string {
+	return fmt.Sprintf("BccOnlyRule{Addresses: %s}", strings.Join(r.Addresses, ", "))
+}
@@ -0,0 +1,64 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"mail-cleaner/internal/rules"
+	"regexp"
+
+	"github.com/emersion/go-imap"
+)
+
+// BodyRule matches the decoded message text: text/plain parts and text/html
+// parts stripped of tags. The body is only fetched for emails that cheaper
+// rules could not decide.
+type BodyRule struct {
+	Text  string
+	Match MatchMode
+	re    *regexp.Regexp
+}
+
+func init() {
+	RegisterRuleFactory("body_rule", func(data map[string]any) (rules.Rule, error) {
+		text, ok := data["text"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid or missing 'text' field")
+		}
+		mode, err := parseMatchMode(data, MatchContains)
+		if err != nil {
+			return nil, err
+		}
+		return NewBodyRule(text, mode)
+	})
+}
+
+func NewBodyRule(text string, mode MatchMode) (*BodyRule, error) {
+	if text == "" {
+		return nil, errors.New("text cannot be empty")
+	}
+	if mode != MatchContains && mode != MatchRegex {
+		return nil, fmt.Errorf("body_rule supports 'contains' or 'regex' match, got: %s", mode)
+	}
+	re, err := compilePattern(mode, text)
+	if err != nil {
+		return nil, err
+	}
+	return &BodyRule{
+		Text:  text,
+		Match: mode,
+		re:    re,
+	}, nil
+}
+
+func (r *BodyRule) ShouldDelete(msg *imap.Message) bool {
+	body := rules.BodyText(msg)
+	return body != "" && matchValue(r.Match.or(MatchContains), r.re, body, r.Text)
+}
+
+func (r *BodyRule) Needs() rules.Needs {
+	return rules.Needs{Body: true}
+}
+
+func (r *BodyRule) String() string {
+	return fmt.Sprintf("BodyRule{Text: %s, Match: %s}", r.Text, r.Match.or(MatchContains))
+}
@@ -0,0 +1,153 @@
+package rule
+
+import (
+	"bytes"
+	"testing"
+
+	"mail-cleaner/internal/rules"
+
+	"github.com/emersion/go-imap"
+)
+
+func withBody(msg *imap.Message, header, text string) *imap.Message {
+	if msg.Body == nil {
+		msg.Body = make(map[*imap.BodySectionName]imap.Literal)
+	}
+	msg.Body[&imap.BodySectionName{
+		BodyPartName: imap.BodyPartName{
+			Specifier: imap.HeaderSpecifier,
+			Fields:    []string{"Content-Type", "Content-Transfer-Encoding"},
+		},
+	}] = bytes.NewBufferString(header)
+	msg.Body[&imap.BodySectionName{
+		BodyPartName: imap.BodyPartName{Specifier: imap.TextSpecifier},
+	}] = bytes.NewBufferString(text)
+	return msg
+}
+
+func TestBodyRule_ShouldDelete(t *testing.T) {
+	htmlHeader := "Content-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n"
+	htmlBody := "<p>Limited time offer!</p><a href=3D\"https://x\">Unsub=\r\nscribe</a>"
+
+	tests := []struct {
+		name string
+		text string
+		mode MatchMode
+		msg  *imap.Message
+		want bool
+	}{
+		{name: "contains in html", text: "unsubscribe", mode: MatchContains, msg: withBody(&imap.Message{}, htmlHeader, htmlBody), want: true},
+		{name: "regex", text: `limited\s+time`, mode: MatchRegex, msg: withBody(&imap.Message{}, htmlHeader, htmlBody), want: true},
+		{name: "tags are not text", text: "href", mode: MatchContains, msg: withBody(&imap.Message{}, htmlHeader, htmlBody), want: false},
+		{name: "body not fetched", text: "unsubscribe", mode: MatchContains, msg: &imap.Message{}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewBodyRule(tt.text, tt.mode)
+			if err != nil {
+				t.Fatalf("NewBodyRule() error = %v", err)
+			}
+			if got := rule.ShouldDelete(tt.msg); got != tt.want {
+				t.Errorf("BodyRule.ShouldDelete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBodyRule_Invalid(t *testing.T) {
+	if _, err := NewBodyRule("", MatchContains); err == nil {
+		t.Errorf("NewBodyRule() expected error for empty text")
+	}
+	if _, err := NewBodyRule("offer", MatchExact); err == nil {
+		t.Errorf("NewBodyRule() expected error for exact match")
+	}
+}
+
+func TestRules_PrecheckAndResume(t *testing.T) {
+	first := &countingRule{result: false}
+	body, err := NewBodyRule("unsubscribe", MatchContains)
+	if err != nil {
+		t.Fatalf("NewBodyRule() error = %v", err)
+	}
+	promo, _ := NewDomainRule("promo.com")
+	promoWithBody, _ := NewAllOfRule([]rules.Rule{promo, body})
+	spam, _ := NewDomainRule("spam.com")
+
+	set := rules.NewRules([]rules.Rule{first, promoWithBody, spam})
+
+	t.Run("decided without body", func(t *testing.T) {
+		msg := newTestMessage("news", "spam.com", "Hello")
+		decision, ok, pending := set.Precheck(msg)
+		if pending != nil {
+			t.Fatalf("Precheck() asked for the body of an email the envelope rules rule out")
+		}
+		if !ok || decision.Action != rules.ActionDelete {
+			t.Errorf("Precheck() = %v, %v, want delete", decision, ok)
+		}
+	})
+
+	t.Run("no match without body", func(t *testing.T) {
+		_, ok, pending := set.Precheck(newTestMessage("friend", "example.com", "Hi"))
+		if ok || pending != nil {
+			t.Errorf("Precheck() = %v, %v, want no match and no body fetch", ok, pending)
+		}
+	})
+
+	t.Run("body needed and matches", func(t *testing.T) {
+		first.calls = 0
+		msg := newTestMessage("news", "promo.com", "Deals")
+		_, ok, pending := set.Precheck(msg)
+		if ok || pending == nil {
+			t.Fatalf("Precheck() = %v, %v, want pending", ok, pending)
+		}
+
+		withBody(msg, "Content-Type: text/plain\r\n", "To unsubscribe click here")
+		decision, ok := set.Resume(msg, pending)
+		if !ok || decision.Action != rules.ActionDelete {
+			t.Errorf("Resume() = %v, %v, want delete", decision, ok)
+		}
+		if first.calls != 1 {
+			t.Errorf("rule before the body rule evaluated %d times, want 1", first.calls)
+		}
+	})
+
+	t.Run("body needed and does not match", func(t *testing.T) {
+		msg := newTestMessage("news", "promo.com", "Deals")
+		_, _, pending := set.Precheck(msg)
+		if pending == nil {
+			t.Fatalf("Precheck() did not ask for the body")
+		}
+		withBody(msg, "Content-Type: text/plain\r\n", "Your receipt")
+		if _, ok := set.Resume(msg, pending); ok {
+			t.Errorf("Resume() matched an email without the phrase")
+		}
+	})
+}
+
+func TestCompositeRule_Prematch(t *testing.T) {
+	body, _ := NewBodyRule("offer", MatchContains)
+	match := &countingRule{result: true}
+	noMatch := &countingRule{result: false}
+
+	tests := []struct {
+		name string
+		rule rules.Rule
+		want rules.Prematch
+	}{
+		{name: "all_of ruled out by cheap child", rule: &AllOfRule{Rules: []rules.Rule{body, noMatch}}, want: rules.NoMatch},
+		{name: "all_of needs body", rule: &AllOfRule{Rules: []rules.Rule{match, body}}, want: rules.NeedBody},
+		{name: "any_of matched by cheap child", rule: &AnyOfRule{Rules: []rules.Rule{body, match}}, want: rules.Match},
+		{name: "any_of needs body", rule: &AnyOfRule{Rules: []rules.Rule{noMatch, body}}, want: rules.NeedBody},
+		{name: "not of body needs body", rule: &NotRule{Rule: body}, want: rules.NeedBody},
+		{name: "not of cheap rule", rule: &NotRule{Rule: noMatch}, want: rules.Match},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.PrematchOf(tt.rule, &imap.Message{}); got != tt.want {
+				t.Errorf("PrematchOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
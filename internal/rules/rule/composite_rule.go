@@ -0,0 +1,316 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mail-cleaner/internal/rules"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// AllOfRule matches when every child rule matches.
+type AllOfRule struct {
+	Rules []rules.Rule
+}
+
+// AnyOfRule matches when at least one child rule matches.
+type AnyOfRule struct {
+	Rules []rules.Rule
+}
+
+// NotRule matches when its child rule does not match.
+type NotRule struct {
+	Rule rules.Rule
+}
+
+func init() {
+	RegisterRuleFactory("all_of", func(data map[string]any) (rules.Rule, error) {
+		children, err := parseChildRules(data)
+		if err != nil {
+			return nil, err
+		}
+		return NewAllOfRule(children)
+	})
+
+	RegisterRuleFactory("any_of", func(data map[string]any) (rules.Rule, error) {
+		children, err := parseChildRules(data)
+		if err != nil {
+			return nil, err
+		}
+		return NewAnyOfRule(children)
+	})
+
+	RegisterRuleFactory("not", func(data map[string]any) (rules.Rule, error) {
+		raw, ok := data["rule"].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid or missing 'rule' field")
+		}
+		child, err := newChildRule(raw)
+		if err != nil {
+			return nil, err
+		}
+		return NewNotRule(child)
+	})
+}
+
+// parseChildRules creates the rules listed in the "rules" field, see
+// newChildRule.
+func parseChildRules(data map[string]any) ([]rules.Rule, error) {
+	rawList, ok := data["rules"].([]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid or missing 'rules' field")
+	}
+
+	children := make([]rules.Rule, 0, len(rawList))
+	for i, item := range rawList {
+		raw, ok := item.(map[string]any)
+		if !ok {
+			closeRules(children)
+			return nil, fmt.Errorf("rule %d in 'rules' is not an object", i)
+		}
+		child, err := newChildRule(raw)
+		if err != nil {
+			closeRules(children)
+			return nil, fmt.Errorf("rule %d in 'rules': %w", i, err)
+		}
+		children = append(children, child)
+	}
+	return children, nil
+}
+
+// topLevelFields only apply to the rules listed in the rules file itself.
+var topLevelFields = []string{"action", "target", "move_to", "folders", "exclude_folders"}
+
+// newChildRule creates a rule nested in a composite. Children are plain
+// matchers, so fields and rule types that would be silently ignored there
+// are rejected.
+func newChildRule(raw map[string]any) (rules.Rule, error) {
+	for _, field := range topLevelFields {
+		if _, ok := raw[field]; ok {
+			return nil, fmt.Errorf("'%s' can only be set on the top-level rule", field)
+		}
+	}
+
+	child, err := newRule(raw)
+	if err != nil {
+		return nil, err
+	}
+	_, allows := child.(rules.Allower)
+	_, decides := child.(rules.Decider)
+	if allows || decides {
+		closeRules([]rules.Rule{child})
+		return nil, fmt.Errorf("%s can only be used as a top-level rule", raw["type"])
+	}
+	return child, nil
+}
+
+func NewAllOfRule(children []rules.Rule) (*AllOfRule, error) {
+	if len(children) == 0 {
+		return nil, errors.New("all_of needs at least one rule")
+	}
+	return &AllOfRule{Rules: children}, nil
+}
+
+func NewAnyOfRule(children []rules.Rule) (*AnyOfRule, error) {
+	if len(children) == 0 {
+		return nil, errors.New("any_of needs at least one rule")
+	}
+	return &AnyOfRule{Rules: children}, nil
+}
+
+func NewNotRule(child rules.Rule) (*NotRule, error) {
+	if child == nil {
+		return nil, errors.New("not needs a rule")
+	}
+	return &NotRule{Rule: child}, nil
+}
+
+func (r *AllOfRule) ShouldDelete(msg *imap.Message) bool {
+	for _, child := range r.Rules {
+		if !child.ShouldDelete(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *AnyOfRule) ShouldDelete(msg *imap.Message) bool {
+	for _, child := range r.Rules {
+		if child.ShouldDelete(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *NotRule) ShouldDelete(msg *imap.Message) bool {
+	return !r.Rule.ShouldDelete(msg)
+}
+
+func (r *AllOfRule) Needs() rules.Needs {
+	return needsOf(r.Rules)
+}
+
+func (r *AnyOfRule) Needs() rules.Needs {
+	return needsOf(r.Rules)
+}
+
+func (r *NotRule) Needs() rules.Needs {
+	return rules.NeedsOf(r.Rule)
+}
+
+// Prematch is NoMatch as soon as one child rules the email out, so the body
+// is only fetched when cheaper children all match.
+func (r *AllOfRule) Prematch(msg *imap.Message) rules.Prematch {
+	result := rules.Match
+	for _, child := range r.Rules {
+		switch rules.PrematchOf(child, msg) {
+		case rules.NoMatch:
+			return rules.NoMatch
+		case rules.NeedBody:
+			result = rules.NeedBody
+		}
+	}
+	return result
+}
+
+func (r *AnyOfRule) Prematch(msg *imap.Message) rules.Prematch {
+	result := rules.NoMatch
+	for _, child := range r.Rules {
+		switch rules.PrematchOf(child, msg) {
+		case rules.Match:
+			return rules.Match
+		case rules.NeedBody:
+			result = rules.NeedBody
+		}
+	}
+	return result
+}
+
+func (r *NotRule) Prematch(msg *imap.Message) rules.Prematch {
+	switch rules.PrematchOf(r.Rule, msg) {
+	case rules.Match:
+		return rules.NoMatch
+	case rules.NoMatch:
+		return rules.Match
+	default:
+		return rules.NeedBody
+	}
+}
+
+func needsOf(children []rules.Rule) rules.Needs {
+	var needs rules.Needs
+	for _, child := range children {
+		needs = needs.Merge(rules.NeedsOf(child))
+	}
+	return needs
+}
+
+func (r *AllOfRule) Close() error {
+	return closeRules(r.Rules)
+}
+
+func (r *AnyOfRule) Close() error {
+	return closeRules(r.Rules)
+}
+
+func (r *NotRule) Close() error {
+	return closeRules([]rules.Rule{r.Rule})
+}
+
+func (r *AllOfRule) String() string {
+	return "AllOf" + describeRules(r.Rules)
+}
+
+func (r *AnyOfRule) String() string {
+	return "AnyOf" + describeRules(r.Rules)
+}
+
+func (r *NotRule) String() string {
+	return fmt.Sprintf("Not{%s}", rules.Describe(r.Rule))
+}
+
+func describeRules(children []rules.Rule) string {
+	names := make([]string, len(children))
+	for i, child := range children {
+		names[i] = rules.Describe(child)
+	}
+	return "{" + strings.Join(names, ", ") + "}"
+}
+
+func closeRules(children []rules.Rule) error {
+	var errs []error
+	for _, child := range children {
+		if closer, ok := child.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SearchCriteria of all_of uses the children that can be searched for; the
+// others are checked on the fetched emails.
+func (r *AllOfRule) SearchCriteria() (*imap.SearchCriteria, bool) {
+	var all []*imap.SearchCriteria
+	for _, child := range r.Rules {
+		if criteria, ok := rules.SearchOf(child); ok {
+			all = append(all, criteria)
+		}
+	}
+	if len(all) == 0 {
+		return nil, false
+	}
+	return rules.AndCriteria(all...), true
+}
+
+// SearchCriteria of any_of needs every child to be searchable.
+func (r *AnyOfRule) SearchCriteria() (*imap.SearchCriteria, bool) {
+	var any []*imap.SearchCriteria
+	for _, child := range r.Rules {
+		criteria, ok := rules.SearchOf(child)
+		if !ok {
+			return nil, false
+		}
+		any = append(any, criteria)
+	}
+	return rules.OrCriteria(any...), true
+}
+
+// AgedCriteria of all_of combines the aging children with the searchable
+// others, since all of them have to match.
+func (r *AllOfRule) AgedCriteria(lastRun time.Time) (*imap.SearchCriteria, bool) {
+	var all []*imap.SearchCriteria
+	aging := false
+	for _, child := range r.Rules {
+		if criteria, ok := rules.AgedOf(child, lastRun); ok {
+			all = append(all, criteria)
+			aging = true
+		} else if criteria, ok := rules.SearchOf(child); ok {
+			all = append(all, criteria)
+		}
+	}
+	if !aging {
+		return nil, false
+	}
+	return rules.AndCriteria(all...), true
+}
+
+// AgedCriteria of any_of only needs the aging children, the others match
+// the same emails as before.
+func (r *AnyOfRule) AgedCriteria(lastRun time.Time) (*imap.SearchCriteria, bool) {
+	var any []*imap.SearchCriteria
+	for _, child := range r.Rules {
+		if criteria, ok := rules.AgedOf(child, lastRun); ok {
+			any = append(any, criteria)
+		}
+	}
+	if len(any) == 0 {
+		return nil, false
+	}
+	return rules.OrCriteria(any...), true
+}
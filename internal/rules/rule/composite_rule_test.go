@@ -0,0 +1,193 @@
+package rule
+
+import (
+	"testing"
+
+	"mail-cleaner/internal/rules"
+
+	"github.com/emersion/go-imap"
+)
+
+type countingRule struct {
+	result bool
+	calls  int
+}
+
+func (r *countingRule) ShouldDelete(msg *imap.Message) bool {
+	r.calls++
+	return r.result
+}
+
+func newTestMessage(mailbox, host, subject string) *imap.Message {
+	return &imap.Message{
+		Envelope: &imap.Envelope{
+			From:    []*imap.Address{{MailboxName: mailbox, HostName: host}},
+			Subject: subject,
+		},
+	}
+}
+
+func TestAllOfRule_ShouldDelete(t *testing.T) {
+	tests := []struct {
+		name      string
+		results   []bool
+		want      bool
+		wantCalls []int
+	}{
+		{name: "all match", results: []bool{true, true, true}, want: true, wantCalls: []int{1, 1, 1}},
+		{name: "first fails - short circuit", results: []bool{false, true, true}, want: false, wantCalls: []int{1, 0, 0}},
+		{name: "middle fails - short circuit", results: []bool{true, false, true}, want: false, wantCalls: []int{1, 1, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			children, counters := countingRules(tt.results)
+			rule, err := NewAllOfRule(children)
+			if err != nil {
+				t.Fatalf("NewAllOfRule() error = %v", err)
+			}
+			if got := rule.ShouldDelete(&imap.Message{}); got != tt.want {
+				t.Errorf("AllOfRule.ShouldDelete() = %v, want %v", got, tt.want)
+			}
+			assertCalls(t, counters, tt.wantCalls)
+		})
+	}
+}
+
+func TestAnyOfRule_ShouldDelete(t *testing.T) {
+	tests := []struct {
+		name      string
+		results   []bool
+		want      bool
+		wantCalls []int
+	}{
+		{name: "none match", results: []bool{false, false, false}, want: false, wantCalls: []int{1, 1, 1}},
+		{name: "first matches - short circuit", results: []bool{true, false, false}, want: true, wantCalls: []int{1, 0, 0}},
+		{name: "last matches", results: []bool{false, false, true}, want: true, wantCalls: []int{1, 1, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			children, counters := countingRules(tt.results)
+			rule, err := NewAnyOfRule(children)
+			if err != nil {
+				t.Fatalf("NewAnyOfRule() error = %v", err)
+			}
+			if got := rule.ShouldDelete(&imap.Message{}); got != tt.want {
+				t.Errorf("AnyOfRule.ShouldDelete() = %v, want %v", got, tt.want)
+			}
+			assertCalls(t, counters, tt.wantCalls)
+		})
+	}
+}
+
+func TestNotRule_ShouldDelete(t *testing.T) {
+	for _, result := range []bool{true, false} {
+		rule, err := NewNotRule(&countingRule{result: result})
+		if err != nil {
+			t.Fatalf("NewNotRule() error = %v", err)
+		}
+		if got := rule.ShouldDelete(&imap.Message{}); got == result {
+			t.Errorf("NotRule.ShouldDelete() = %v for child %v", got, result)
+		}
+	}
+}
+
+func TestNewCompositeRule_Empty(t *testing.T) {
+	if _, err := NewAllOfRule(nil); err == nil {
+		t.Errorf("NewAllOfRule(nil) expected error")
+	}
+	if _, err := NewAnyOfRule(nil); err == nil {
+		t.Errorf("NewAnyOfRule(nil) expected error")
+	}
+	if _, err := NewNotRule(nil); err == nil {
+		t.Errorf("NewNotRule(nil) expected error")
+	}
+}
+
+func TestCreateFromFile_NestedComposites(t *testing.T) {
+	// promo.com emails about a sale, except the ones from the boss,
+	// or anything from spam.com
+	path := writeRulesFile(t, `[
+		{
+			"type": "any_of",
+			"rules": [
+				{
+					"type": "all_of",
+					"rules": [
+						{"type": "domain_rule", "domain": "promo.com"},
+						{"type": "theme_rule", "text": "sale"},
+						{"type": "not", "rule": {"type": "address_rule", "address": "boss@promo.com"}}
+					]
+				},
+				{"type": "domain_rule", "domain": "spam.com"}
+			]
+		}
+	]`)
+
+	got, err := CreateFromFile(path)
+	if err != nil {
+		t.Fatalf("CreateFromFile() error = %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("CreateFromFile() returned %d rules, want 1", len(got))
+	}
+	set := rules.NewRules(got)
+
+	tests := []struct {
+		name string
+		msg  *imap.Message
+		want bool
+	}{
+		{name: "promo sale", msg: newTestMessage("news", "promo.com", "Big SALE today"), want: true},
+		{name: "promo without sale", msg: newTestMessage("news", "promo.com", "Your order"), want: false},
+		{name: "promo sale from boss", msg: newTestMessage("boss", "promo.com", "Sale planning"), want: false},
+		{name: "spam domain", msg: newTestMessage("any", "spam.com", "Hello"), want: true},
+		{name: "unrelated", msg: newTestMessage("friend", "example.com", "Sale"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := set.ShouldDelete(tt.msg); got != tt.want {
+				t.Errorf("ShouldDelete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateFromFile_InvalidComposite(t *testing.T) {
+	assertInvalidRules(t,
+		`{"type": "all_of", "rules": []}`,
+		`{"type": "any_of", "rules": [{"type": "unknown_rule"}]}`,
+		`{"type": "not"}`,
+		`{"type": "not", "rule": {"type": "theme_rule", "text": "[broken", "match": "regex"}}`,
+		// fields and types that only work at the top level
+		`{"type": "all_of", "rules": [{"type": "domain_rule", "domain": "shop.com", "action": "flag"}]}`,
+		`{"type": "any_of", "rules": [{"type": "domain_rule", "domain": "shop.com", "target": "Promotions"}]}`,
+		`{"type": "all_of", "rules": [{"type": "domain_rule", "domain": "shop.com", "move_to": "Trash"}]}`,
+		`{"type": "any_of", "rules": [{"type": "theme_rule", "text": "sale", "folders": ["INBOX"]}]}`,
+		`{"type": "not", "rule": {"type": "theme_rule", "text": "sale", "exclude_folders": ["Archive"]}}`,
+		`{"type": "any_of", "rules": [{"type": "allow_domain", "domain": "shop.com"}]}`,
+		`{"type": "not", "rule": {"type": "allow_flags", "has": "flagged"}}`,
+		`{"type": "all_of", "rules": [{"type": "ai_local_rule", "enabled": false}]}`,
+	)
+}
+
+func countingRules(results []bool) ([]rules.Rule, []*countingRule) {
+	children := make([]rules.Rule, len(results))
+	counters := make([]*countingRule, len(results))
+	for i, result := range results {
+		counters[i] = &countingRule{result: result}
+		children[i] = counters[i]
+	}
+	return children, counters
+}
+
+func assertCalls(t *testing.T, counters []*countingRule, want []int) {
+	t.Helper()
+	for i, counter := range counters {
+		if counter.calls != want[i] {
+			t.Errorf("child %d called %d times, want %d", i, counter.calls, want[i])
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"mail-cleaner/internal/rules"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+const (
+	// DateInternal is the date the server received the email (INTERNALDATE).
+	DateInternal = "internal"
+	// DateHeader is the Date header of the email.
+	DateHeader = "header"
+)
+
+const day = 24 * time.Hour
+
+// DateRule matches emails by age or by a fixed date window. Ages are measured
+// when the email is evaluated, so a long running process stays correct.
+type DateRule struct {
+	OlderThan time.Duration
+	NewerThan time.Duration
+	After     time.Time
+	Before    time.Time
+	// Source is DateInternal (default) or DateHeader.
+	Source string
+	now    func() time.Time
+}
+
+func init() {
+	RegisterRuleFactory("older_than", func(data map[string]any) (rules.Rule, error) {
+		days, err := parseDays(data)
+		if err != nil {
+			return nil, err
+		}
+		return NewDateRule(&DateRule{OlderThan: days, Source: parseDateSource(data)})
+	})
+
+	RegisterRuleFactory("newer_than", func(data map[string]any) (rules.Rule, error) {
+		days, err := parseDays(data)
+		if err != nil {
+			return nil, err
+		}
+		return NewDateRule(&DateRule{NewerThan: days, Source: parseDateSource(data)})
+	})
+
+	RegisterRuleFactory("date_between", func(data map[string]any) (rules.Rule, error) {
+		after, err := parseDate(data, "after")
+		if err != nil {
+			return nil, err
+		}
+		before, err := parseDate(data, "before")
+		if err != nil {
+			return nil, err
+		}
+		return NewDateRule(&DateRule{After: after, Before: before, Source: parseDateSource(data)})
+	})
+}
+
+func NewDateRule(r *DateRule) (*DateRule, error) {
+	if r.OlderThan == 0 && r.NewerThan == 0 && r.After.IsZero() && r.Before.IsZero() {
+		return nil, errors.New("date rule needs an age or a date window")
+	}
+	if !r.After.IsZero() && !r.Before.IsZero() && !r.After.Before(r.Before) {
+		return nil, errors.New("'after' must be earlier than 'before'")
+	}
+	if r.Source == "" {
+		r.Source = DateInternal
+	}
+	if r.Source != DateInternal && r.Source != DateHeader {
+		return nil, fmt.Errorf("date source must be '%s' or '%s', got: %s", DateInternal, DateHeader, r.Source)
+	}
+	if r.now == nil {
+		r.now = time.Now
+	}
+	return r, nil
+}
+
+func (r *DateRule) ShouldDelete(msg *imap.Message) bool {
+	date := r.messageDate(msg)
+	if date.IsZero() {
+		return false
+	}
+
+	now := r.now()
+	if r.OlderThan > 0 && !date.Before(now.Add(-r.OlderThan)) {
+		return false
+	}
+	if r.NewerThan > 0 && !date.After(now.Add(-r.NewerThan)) {
+		return false
+	}
+	if !r.After.IsZero() && date.Before(r.After) {
+		return false
+	}
+	if !r.Before.IsZero() && !date.Before(r.Before) {
+		return false
+	}
+	return true
+}
+
+// messageDate returns the date selected by Source. INTERNALDATE falls back to
+// the Date header for servers that don't return it.
+func (r *DateRule) messageDate(msg *imap.Message) time.Time {
+	if r.Source != DateHeader && !msg.InternalDate.IsZero() {
+		return msg.InternalDate
+	}
+	if msg.Envelope != nil {
+		return msg.Envelope.Date
+	}
+	return time.Time{}
+}
+
+func (r *DateRule) String() string {
+	switch {
+	case r.OlderThan > 0:
+		return fmt.Sprintf("DateRule{OlderThan: %dd}", r.OlderThan/day)
+	case r.NewerThan > 0:
+		return fmt.Sprintf("DateRule{NewerThan: %dd}", r.NewerThan/day)
+	default:
+		return fmt.Sprintf("DateRule{After: %s, Before: %s}", formatDate(r.After), formatDate(r.Before))
+	}
+}
+
+func parseDays(data map[string]any) (time.Duration, error) {
+	days, ok := data["days"].(float64)
+	if !ok || days <= 0 {
+		return 0, fmt.Errorf("invalid or missing 'days' field")
+	}
+	return time.Duration(days * float64(day)), nil
+}
+
+// parseDate reads an optional date field as YYYY-MM-DD or RFC 3339.
+func parseDate(data map[string]any, field string) (time.Time, error) {
+	raw, exists := data[field]
+	if !exists {
+		return time.Time{}, nil
+	}
+	value, ok := raw.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid '%s' field", field)
+	}
+	if date, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return date, nil
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid '%s' date %q, use YYYY-MM-DD", field, value)
+	}
+	return date, nil
+}
+
+func parseDateSource(data map[string]any) string {
+	source, _ := data["date_source"].(string)
+	return source
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.DateOnly)
+}
+
+// SearchCriteria widens the rule's dates by a day on each side: SEARCH only
+// compares dates, in the server's time zone.
+func (r *DateRule) SearchCriteria() (*imap.SearchCriteria, bool) {
+	var since, before time.Time
+	now := r.now()
+	if r.OlderThan > 0 {
+		before = now.Add(-r.OlderThan)
+	}
+	if r.NewerThan > 0 {
+		since = now.Add(-r.NewerThan)
+	}
+	if !r.After.IsZero() && r.After.After(since) {
+		since = r.After
+	}
+	if !r.Before.IsZero() && (before.IsZero() || r.Before.Before(before)) {
+		before = r.Before
+	}
+	if !since.IsZero() {
+		since = since.Add(-day)
+	}
+	if !before.IsZero() {
+		before = before.Add(2 * day)
+	}
+
+	criteria := imap.NewSearchCriteria()
+	if r.Source == DateHeader {
+		criteria.SentSince, criteria.SentBefore = since, before
+	} else {
+		criteria.Since, criteria.Before = since, before
+	}
+	return criteria, true
+}
+
+// AgedCriteria is the rule's search limited to the emails that got older than
+// OlderThan since lastRun. Fixed date windows match the same emails on every
+// run, so only older_than ages.
+func (r *DateRule) AgedCriteria(lastRun time.Time) (*imap.SearchCriteria, bool) {
+	if r.OlderThan == 0 {
+		return nil, false
+	}
+	criteria, _ := r.SearchCriteria()
+	if lastRun.IsZero() {
+		return criteria, true
+	}
+
+	since := lastRun.Add(-r.OlderThan - day)
+	if r.Source == DateHeader {
+		if since.After(criteria.SentSince) {
+			criteria.SentSince = since
+		}
+	} else if since.After(criteria.Since) {
+		criteria.Since = since
+	}
+	return criteria, true
+}
@@ -0,0 +1,120 @@
+package rule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestDateRule_ShouldDelete(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	fixedNow := func() time.Time { return now }
+
+	tests := []struct {
+		name string
+		rule *DateRule
+		msg  *imap.Message
+		want bool
+	}{
+		{
+			name: "older than 30 days",
+			rule: &DateRule{OlderThan: 30 * day},
+			msg:  &imap.Message{InternalDate: now.AddDate(0, 0, -31)},
+			want: true,
+		},
+		{
+			name: "not older than 30 days",
+			rule: &DateRule{OlderThan: 30 * day},
+			msg:  &imap.Message{InternalDate: now.AddDate(0, 0, -29)},
+			want: false,
+		},
+		{
+			name: "newer than 7 days",
+			rule: &DateRule{NewerThan: 7 * day},
+			msg:  &imap.Message{InternalDate: now.AddDate(0, 0, -1)},
+			want: true,
+		},
+		{
+			name: "not newer than 7 days",
+			rule: &DateRule{NewerThan: 7 * day},
+			msg:  &imap.Message{InternalDate: now.AddDate(0, 0, -8)},
+			want: false,
+		},
+		{
+			name: "inside date window",
+			rule: &DateRule{After: now.AddDate(0, -1, 0), Before: now},
+			msg:  &imap.Message{InternalDate: now.AddDate(0, 0, -10)},
+			want: true,
+		},
+		{
+			name: "before is exclusive",
+			rule: &DateRule{After: now.AddDate(0, -1, 0), Before: now},
+			msg:  &imap.Message{InternalDate: now},
+			want: false,
+		},
+		{
+			name: "internal date falls back to header",
+			rule: &DateRule{OlderThan: 30 * day},
+			msg:  &imap.Message{Envelope: &imap.Envelope{Date: now.AddDate(-1, 0, 0)}},
+			want: true,
+		},
+		{
+			name: "header source ignores internal date",
+			rule: &DateRule{OlderThan: 30 * day, Source: DateHeader},
+			msg: &imap.Message{
+				InternalDate: now.AddDate(-1, 0, 0),
+				Envelope:     &imap.Envelope{Date: now.AddDate(0, 0, -1)},
+			},
+			want: false,
+		},
+		{
+			name: "no date - should not delete",
+			rule: &DateRule{OlderThan: 30 * day},
+			msg:  &imap.Message{},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.now = fixedNow
+			rule, err := NewDateRule(tt.rule)
+			if err != nil {
+				t.Fatalf("NewDateRule() error = %v", err)
+			}
+			if got := rule.ShouldDelete(tt.msg); got != tt.want {
+				t.Errorf("DateRule.ShouldDelete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateFromFile_DateRules(t *testing.T) {
+	path := writeRulesFile(t, `[
+		{"type": "older_than", "days": 30},
+		{"type": "newer_than", "days": 7, "date_source": "header"},
+		{"type": "date_between", "after": "2024-01-01", "before": "2024-07-01"}
+	]`)
+
+	got, err := CreateFromFile(path)
+	if err != nil {
+		t.Fatalf("CreateFromFile() error = %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("CreateFromFile() returned %d rules, want 3", len(got))
+	}
+
+	between := got[2].(*DateRule)
+	msg := &imap.Message{InternalDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)}
+	if !between.ShouldDelete(msg) {
+		t.Errorf("date_between did not match a date inside the window")
+	}
+
+	assertInvalidRules(t,
+		`{"type": "older_than"}`,
+		`{"type": "date_between", "after": "2024-07-01", "before": "2024-01-01"}`,
+		`{"type": "date_between", "after": "01.01.2024"}`,
+		`{"type": "older_than", "days": 30, "date_source": "sent"}`,
+	)
+}
@@ -4,13 +4,30 @@ import (
 	"errors"
 	"fmt"
 	"mail-cleaner/internal/rules"
+	"regexp"
 	"strings"
 
 	"github.com/emersion/go-imap"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Domain specific match modes. They compare whole labels, so "mail.com" never
+// matches "gmail.com".
+const (
+	// MatchSubdomain matches the domain itself and all its subdomains.
+	MatchSubdomain MatchMode = "subdomain"
+	// MatchRegistrable matches any domain with the same registrable domain
+	// (eTLD+1 from the public suffix list), e.g. "shop.example.co.uk" and
+	// "news.example.co.uk".
+	MatchRegistrable MatchMode = "registrable"
 )
 
 type DomainRule struct {
 	Domain string
+	Match  MatchMode
+	// Fields are the address lists checked, the sender when empty.
+	Fields []AddressField
+	re     *regexp.Regexp
 }
 
 func init() {
@@ -19,26 +36,61 @@ func init() {
 		if !ok {
 			return nil, fmt.Errorf("invalid or missing 'domain' field")
 		}
-		return NewDomainRule(domain)
+		mode, err := parseMatchMode(data, MatchContains)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := parseFields(data)
+		if err != nil {
+			return nil, err
+		}
+		rule, err := NewDomainRuleWithMatch(domain, mode)
+		if err != nil {
+			return nil, err
+		}
+		rule.Fields = fields
+		return rule, nil
 	})
 }
 
 func NewDomainRule(domain string) (*DomainRule, error) {
+	return NewDomainRuleWithMatch(domain, MatchContains)
+}
+
+func NewDomainRuleWithMatch(domain string, mode MatchMode) (*DomainRule, error) {
 	if domain == "" {
 		return nil, errors.New("domain cannot be empty")
 	}
+	var re *regexp.Regexp
+	switch mode {
+	case MatchSubdomain:
+		// compared label by label, nothing to compile
+	case MatchRegistrable:
+		if _, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(domain)); err != nil {
+			return nil, fmt.Errorf("domain %q has no registrable part: %w", domain, err)
+		}
+	default:
+		var err error
+		if re, err = compilePattern(mode, domain); err != nil {
+			return nil, err
+		}
+	}
 	return &DomainRule{
 		Domain: domain,
+		Match:  mode,
+		re:     re,
 	}, nil
 }
 
 func (d *DomainRule) ShouldDelete(msg *imap.Message) bool {
-	if msg.Envelope == nil {
-		return false
-	}
-	for _, addr := range msg.Envelope.From {
+	return d.Matches(msg)
+}
+
+// Matches reports whether an address in one of the rule's fields is at the
+// rule's domain, by default whether the email is from it.
+func (d *DomainRule) Matches(msg *imap.Message) bool {
+	for _, addr := range addresses(msg, d.Fields) {
 		if d.apply(addr.HostName, d.Domain) {
-			fmt.Printf("Deleting email from domain: %s\n", d.Domain)
 			return true
 		}
 	}
@@ -46,7 +98,66 @@ func (d *DomainRule) ShouldDelete(msg *imap.Message) bool {
 }
 
 func (d *DomainRule) apply(emailDomain, ruleDomain string) bool {
-	emailDomainLower := strings.ToLower(emailDomain)
-	ruleDomainLower := strings.ToLower(ruleDomain)
-	return strings.Contains(emailDomainLower, ruleDomainLower)
+	switch mode := d.Match.or(MatchContains); mode {
+	case MatchSubdomain:
+		return isSubdomain(strings.ToLower(emailDomain), strings.ToLower(ruleDomain))
+	case MatchRegistrable:
+		return sameRegistrableDomain(strings.ToLower(emailDomain), strings.ToLower(ruleDomain))
+	default:
+		return matchValue(mode, d.re, emailDomain, ruleDomain)
+	}
+}
+
+func isSubdomain(domain, parent string) bool {
+	return domain == parent || strings.HasSuffix(domain, "."+parent)
+}
+
+func sameRegistrableDomain(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	registrableA, err := publicsuffix.EffectiveTLDPlusOne(a)
+	if err != nil {
+		return false
+	}
+	registrableB, err := publicsuffix.EffectiveTLDPlusOne(b)
+	if err != nil {
+		return false
+	}
+	return registrableA == registrableB
+}
+
+func (d *DomainRule) Needs() rules.Needs {
+	return fieldsNeeds(d.Fields)
+}
+
+func (d *DomainRule) String() string {
+	extra := ""
+	if d.Match.or(MatchContains) != MatchContains {
+		extra += fmt.Sprintf(", Match: %s", d.Match)
+	}
+	if !isDefaultFields(d.Fields) {
+		extra += fmt.Sprintf(", Field: %s", fieldsLabel(d.Fields))
+	}
+	return fmt.Sprintf("DomainRule{Domain: %s%s}", d.Domain, extra)
+}
+
+func (d *DomainRule) SearchCriteria() (*imap.SearchCriteria, bool) {
+	var text string
+	switch mode := d.Match.or(MatchContains); mode {
+	case MatchSubdomain:
+		text = d.Domain
+	case MatchRegistrable:
+		registrable, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(d.Domain))
+		if err != nil {
+			return nil, false
+		}
+		text = registrable
+	default:
+		var ok bool
+		if text, ok = searchText(mode, d.Domain); !ok {
+			return nil, false
+		}
+	}
+	return fieldsCriteria(d.Fields, text), true
 }
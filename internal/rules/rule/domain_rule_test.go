@@ -75,3 +75,45 @@ func Test_apply(t *testing.T) {
 		})
 	}
 }
+
+func TestDomainRule_applyLabelAware(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        MatchMode
+		emailDomain string
+		ruleDomain  string
+		want        bool
+	}{
+		{name: "contains keeps legacy substring match", mode: MatchContains, emailDomain: "gmail.com", ruleDomain: "mail.com", want: true},
+		{name: "exact domain", mode: MatchExact, emailDomain: "Mail.com", ruleDomain: "mail.com", want: true},
+		{name: "exact rejects subdomain", mode: MatchExact, emailDomain: "news.mail.com", ruleDomain: "mail.com", want: false},
+		{name: "subdomain matches itself", mode: MatchSubdomain, emailDomain: "mail.com", ruleDomain: "mail.com", want: true},
+		{name: "subdomain matches child", mode: MatchSubdomain, emailDomain: "promo.News.Mail.com", ruleDomain: "mail.com", want: true},
+		{name: "subdomain respects label boundary", mode: MatchSubdomain, emailDomain: "gmail.com", ruleDomain: "mail.com", want: false},
+		{name: "subdomain rejects other tld", mode: MatchSubdomain, emailDomain: "mail.com.ua", ruleDomain: "mail.com", want: false},
+		{name: "registrable same site", mode: MatchRegistrable, emailDomain: "shop.example.co.uk", ruleDomain: "news.example.co.uk", want: true},
+		{name: "registrable different site", mode: MatchRegistrable, emailDomain: "other.co.uk", ruleDomain: "example.co.uk", want: false},
+		{name: "registrable respects label boundary", mode: MatchRegistrable, emailDomain: "hotmail.com", ruleDomain: "mail.com", want: false},
+		{name: "registrable empty email domain", mode: MatchRegistrable, emailDomain: "", ruleDomain: "mail.com", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewDomainRuleWithMatch(tt.ruleDomain, tt.mode)
+			if err != nil {
+				t.Fatalf("NewDomainRuleWithMatch() error = %v", err)
+			}
+			got := rule.apply(tt.emailDomain, tt.ruleDomain)
+			if got != tt.want {
+				t.Errorf("apply(%q, %q) with %s = %v, want %v",
+					tt.emailDomain, tt.ruleDomain, tt.mode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDomainRuleWithMatch_RegistrableInvalid(t *testing.T) {
+	if _, err := NewDomainRuleWithMatch("co.uk", MatchRegistrable); err == nil {
+		t.Errorf("NewDomainRuleWithMatch() expected error for a public suffix")
+	}
+}
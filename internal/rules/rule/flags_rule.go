@@ -0,0 +1,129 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"mail-cleaner/internal/rules"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// systemFlags maps the short names accepted in rules files to IMAP system
+// flags. Any other name is a keyword, e.g. "$Junk" or "$Label1".
+var systemFlags = map[string]string{
+	"seen":     imap.SeenFlag,
+	"answered": imap.AnsweredFlag,
+	"flagged":  imap.FlaggedFlag,
+	"deleted":  imap.DeletedFlag,
+	"draft":    imap.DraftFlag,
+	"recent":   imap.RecentFlag,
+}
+
+// FlagsRule matches emails by their IMAP flags and keywords. Every flag in
+// Has must be set and none of the flags in Lacks.
+type FlagsRule struct {
+	Has   []string
+	Lacks []string
+}
+
+func init() {
+	RegisterRuleFactory("flags_rule", func(data map[string]any) (rules.Rule, error) {
+		return parseFlagsRule(data)
+	})
+
+	RegisterRuleFactory("allow_flags", func(data map[string]any) (rules.Rule, error) {
+		rule, err := parseFlagsRule(data)
+		if err != nil {
+			return nil, err
+		}
+		return NewAllowRule(rule), nil
+	})
+}
+
+func parseFlagsRule(data map[string]any) (*FlagsRule, error) {
+	has, err := parseStrings(data, "has")
+	if err != nil {
+		return nil, err
+	}
+	lacks, err := parseStrings(data, "lacks")
+	if err != nil {
+		return nil, err
+	}
+	return NewFlagsRule(has, lacks)
+}
+
+func NewFlagsRule(has, lacks []string) (*FlagsRule, error) {
+	if len(has) == 0 && len(lacks) == 0 {
+		return nil, errors.New("flags rule needs 'has' or 'lacks'")
+	}
+	r := &FlagsRule{}
+	for _, flag := range has {
+		if flag == "" {
+			return nil, errors.New("flag cannot be empty")
+		}
+		r.Has = append(r.Has, flagName(flag))
+	}
+	for _, flag := range lacks {
+		if flag == "" {
+			return nil, errors.New("flag cannot be empty")
+		}
+		r.Lacks = append(r.Lacks, flagName(flag))
+	}
+	return r, nil
+}
+
+// flagName turns "seen" or "\Seen" into the canonical "\Seen".
+func flagName(flag string) string {
+	if system, ok := systemFlags[strings.ToLower(flag)]; ok {
+		return system
+	}
+	return imap.CanonicalFlag(flag)
+}
+
+func (r *FlagsRule) ShouldDelete(msg *imap.Message) bool {
+	return r.Matches(msg)
+}
+
+// Matches reports whether the email has all flags in Has and none in Lacks.
+func (r *FlagsRule) Matches(msg *imap.Message) bool {
+	if msg.Flags == nil {
+		// flags were not fetched
+		return false
+	}
+	for _, flag := range r.Has {
+		if !hasFlag(msg, flag) {
+			return false
+		}
+	}
+	for _, flag := range r.Lacks {
+		if hasFlag(msg, flag) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasFlag(msg *imap.Message, flag string) bool {
+	for _, f := range msg.Flags {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *FlagsRule) String() string {
+	switch {
+	case len(r.Lacks) == 0:
+		return fmt.Sprintf("FlagsRule{Has: %s}", strings.Join(r.Has, " "))
+	case len(r.Has) == 0:
+		return fmt.Sprintf("FlagsRule{Lacks: %s}", strings.Join(r.Lacks, " "))
+	default:
+		return fmt.Sprintf("FlagsRule{Has: %s, Lacks: %s}", strings.Join(r.Has, " "), strings.Join(r.Lacks, " "))
+	}
+}
+
+func (r *FlagsRule) SearchCriteria() (*imap.SearchCriteria, bool) {
+	return &imap.SearchCriteria{WithFlags: r.Has, WithoutFlags: r.Lacks}, true
+}
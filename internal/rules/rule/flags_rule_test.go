@@ -0,0 +1,83 @@
+package rule
+
+import (
+	"testing"
+
+	"mail-cleaner/internal/rules"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestFlagsRule_ShouldDelete(t *testing.T) {
+	tests := []struct {
+		name  string
+		has   []string
+		lacks []string
+		flags []string
+		want  bool
+	}{
+		{name: "read", has: []string{"seen"}, flags: []string{imap.SeenFlag}, want: true},
+		{name: "unread", has: []string{"seen"}, flags: []string{}, want: false},
+		{name: "system flag syntax", has: []string{`\seen`}, flags: []string{imap.SeenFlag}, want: true},
+		{name: "read and not flagged", has: []string{"seen"}, lacks: []string{"flagged", "answered"}, flags: []string{imap.SeenFlag}, want: true},
+		{name: "read but answered", has: []string{"seen"}, lacks: []string{"flagged", "answered"}, flags: []string{imap.SeenFlag, imap.AnsweredFlag}, want: false},
+		{name: "keyword", has: []string{"$Junk"}, flags: []string{"$junk"}, want: true},
+		{name: "flags not fetched", lacks: []string{"flagged"}, flags: nil, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewFlagsRule(tt.has, tt.lacks)
+			if err != nil {
+				t.Fatalf("NewFlagsRule() error = %v", err)
+			}
+			if got := rule.ShouldDelete(&imap.Message{Flags: tt.flags}); got != tt.want {
+				t.Errorf("FlagsRule.ShouldDelete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateFromFile_AllowFlags(t *testing.T) {
+	// read notifications are deleted, unless we flagged or replied to them
+	path := writeRulesFile(t, `[
+		{"type": "all_of", "rules": [
+			{"type": "domain_rule", "domain": "notifications.com"},
+			{"type": "flags_rule", "has": "seen"}
+		]},
+		{"type": "allow_flags", "has": "flagged"},
+		{"type": "allow_flags", "has": "answered"}
+	]`)
+
+	got, err := CreateFromFile(path)
+	if err != nil {
+		t.Fatalf("CreateFromFile() error = %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("CreateFromFile() returned %d rules, want 3", len(got))
+	}
+	set := rules.NewRules(got)
+
+	tests := []struct {
+		name  string
+		flags []string
+		want  bool
+	}{
+		{name: "read", flags: []string{imap.SeenFlag}, want: true},
+		{name: "unread", flags: []string{}, want: false},
+		{name: "read and flagged", flags: []string{imap.SeenFlag, imap.FlaggedFlag}, want: false},
+		{name: "read and answered", flags: []string{imap.SeenFlag, imap.AnsweredFlag}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := newTestMessage("alerts", "notifications.com", "Build passed")
+			msg.Flags = tt.flags
+			if got := set.ShouldDelete(msg); got != tt.want {
+				t.Errorf("ShouldDelete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	assertInvalidRules(t, `{"type": "flags_rule"}`)
+}
@@ -0,0 +1,108 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"mail-cleaner/internal/rules"
+	"net/textproto"
+	"regexp"
+
+	"github.com/emersion/go-imap"
+)
+
+const (
+	// MatchExists matches when the header is present, whatever its value.
+	MatchExists MatchMode = "exists"
+	// MatchEquals is an alias of MatchExact that reads better for headers.
+	MatchEquals MatchMode = "equals"
+)
+
+// HeaderRule matches any named header, e.g. List-Id, List-Unsubscribe,
+// Precedence or X-Mailer. Only the headers used by loaded rules are fetched.
+type HeaderRule struct {
+	Header string
+	Value  string
+	Match  MatchMode
+	re     *regexp.Regexp
+}
+
+func init() {
+	RegisterRuleFactory("header_rule", func(data map[string]any) (rules.Rule, error) {
+		header, ok := data["header"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid or missing 'header' field")
+		}
+		value, _ := data["value"].(string)
+		defaultMode := MatchContains
+		if value == "" {
+			defaultMode = MatchExists
+		}
+		mode, err := parseMatchMode(data, defaultMode)
+		if err != nil {
+			return nil, err
+		}
+		return NewHeaderRule(header, value, mode)
+	})
+}
+
+func NewHeaderRule(header, value string, mode MatchMode) (*HeaderRule, error) {
+	if header == "" {
+		return nil, errors.New("header cannot be empty")
+	}
+	if mode == MatchEquals {
+		mode = MatchExact
+	}
+
+	var re *regexp.Regexp
+	if mode != MatchExists {
+		if value == "" {
+			return nil, fmt.Errorf("match %s needs a 'value'", mode)
+		}
+		var err error
+		if re, err = compilePattern(mode, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return &HeaderRule{
+		Header: textproto.CanonicalMIMEHeaderKey(header),
+		Value:  value,
+		Match:  mode,
+		re:     re,
+	}, nil
+}
+
+func (r *HeaderRule) ShouldDelete(msg *imap.Message) bool {
+	values := rules.Header(msg).Values(r.Header)
+	if r.Match == MatchExists {
+		return len(values) > 0
+	}
+	for _, value := range values {
+		if matchValue(r.Match, r.re, value, r.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *HeaderRule) Needs() rules.Needs {
+	return rules.Needs{Headers: []string{r.Header}}
+}
+
+func (r *HeaderRule) String() string {
+	if r.Match == MatchExists {
+		return fmt.Sprintf("HeaderRule{Header: %s, Match: exists}", r.Header)
+	}
+	return fmt.Sprintf("HeaderRule{Header: %s, Value: %s, Match: %s}", r.Header, r.Value, r.Match)
+}
+
+func (r *HeaderRule) SearchCriteria() (*imap.SearchCriteria, bool) {
+	// an empty value matches every email with the header (RFC 3501)
+	text := ""
+	if r.Match != MatchExists {
+		text, _ = searchText(r.Match, r.Value)
+	}
+	criteria := imap.NewSearchCriteria()
+	criteria.Header.Add(r.Header, text)
+	return criteria, true
+}
@@ -0,0 +1,112 @@
+package rule
+
+import (
+	"bytes"
+	"testing"
+
+	"mail-cleaner/internal/rules"
+
+	"github.com/emersion/go-imap"
+)
+
+func newHeaderMessage(header string) *imap.Message {
+	section := &imap.BodySectionName{
+		BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier},
+	}
+	return &imap.Message{
+		Body: map[*imap.BodySectionName]imap.Literal{
+			section: bytes.NewBufferString(header),
+		},
+	}
+}
+
+func TestHeaderRule_ShouldDelete(t *testing.T) {
+	header := "List-Id: Weekly News <news.example.com>\r\n" +
+		"Precedence: bulk\r\n" +
+		"X-Mailer: =?UTF-8?B?0KDQsNGB0YHRi9C70LrQsA==?=\r\n" +
+		"\r\n"
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		mode   MatchMode
+		want   bool
+	}{
+		{name: "exists", header: "list-id", mode: MatchExists, want: true},
+		{name: "missing header", header: "List-Unsubscribe", mode: MatchExists, want: false},
+		{name: "equals", header: "Precedence", value: "BULK", mode: MatchEquals, want: true},
+		{name: "equals no match", header: "Precedence", value: "list", mode: MatchEquals, want: false},
+		{name: "contains", header: "List-Id", value: "news.example.com", mode: MatchContains, want: true},
+		{name: "regex", header: "List-Id", value: `<[a-z]+\.example\.com>$`, mode: MatchRegex, want: true},
+		{name: "encoded word is decoded", header: "X-Mailer", value: "Рассылка", mode: MatchEquals, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewHeaderRule(tt.header, tt.value, tt.mode)
+			if err != nil {
+				t.Fatalf("NewHeaderRule() error = %v", err)
+			}
+			msg := newHeaderMessage(header)
+			if got := rule.ShouldDelete(msg); got != tt.want {
+				t.Errorf("HeaderRule.ShouldDelete() = %v, want %v", got, tt.want)
+			}
+			// the fetched section must still be readable by other rules
+			if got := rule.ShouldDelete(msg); got != tt.want {
+				t.Errorf("HeaderRule.ShouldDelete() second call = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderRule_NoHeadersFetched(t *testing.T) {
+	rule, err := NewHeaderRule("List-Id", "", MatchExists)
+	if err != nil {
+		t.Fatalf("NewHeaderRule() error = %v", err)
+	}
+	if rule.ShouldDelete(&imap.Message{}) {
+		t.Errorf("HeaderRule.ShouldDelete() = true without fetched headers")
+	}
+}
+
+func TestNewHeaderRule_Invalid(t *testing.T) {
+	if _, err := NewHeaderRule("", "x", MatchContains); err == nil {
+		t.Errorf("NewHeaderRule() expected error for empty header")
+	}
+	if _, err := NewHeaderRule("List-Id", "", MatchContains); err == nil {
+		t.Errorf("NewHeaderRule() expected error for contains without value")
+	}
+}
+
+func TestCreateFromFile_HeaderNeeds(t *testing.T) {
+	path := writeRulesFile(t, `[
+		{"type": "header_rule", "header": "List-Unsubscribe"},
+		{
+			"type": "all_of",
+			"action": "mark_read",
+			"rules": [
+				{"type": "header_rule", "header": "precedence", "value": "bulk", "match": "equals"},
+				{"type": "not", "rule": {"type": "header_rule", "header": "X-Mailer", "value": "Outlook"}}
+			]
+		},
+		{"type": "header_rule", "header": "list-unsubscribe", "value": "mailto:"},
+		{"type": "domain_rule", "domain": "example.com"}
+	]`)
+
+	got, err := CreateFromFile(path)
+	if err != nil {
+		t.Fatalf("CreateFromFile() error = %v", err)
+	}
+
+	needs := rules.NewRules(got).Needs()
+	want := []string{"List-Unsubscribe", "Precedence", "X-Mailer"}
+	if len(needs.Headers) != len(want) {
+		t.Fatalf("Needs().Headers = %v, want %v", needs.Headers, want)
+	}
+	for i := range want {
+		if needs.Headers[i] != want[i] {
+			t.Errorf("Needs().Headers = %v, want %v", needs.Headers, want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package rule
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type MatchMode string
+
+const (
+	MatchExact    MatchMode = "exact"
+	MatchContains MatchMode = "contains"
+	MatchSuffix   MatchMode = "suffix"
+	MatchGlob     MatchMode = "glob"
+	MatchRegex    MatchMode = "regex"
+)
+
+// compilePattern validates mode and compiles glob and regex patterns once,
+// when the rule is created. It returns nil for the plain string modes.
+func compilePattern(mode MatchMode, value string) (*regexp.Regexp, error) {
+	switch mode {
+	case MatchExact, MatchContains, MatchSuffix:
+		return nil, nil
+	case MatchGlob:
+		re, err := regexp.Compile("(?i)^" + globToRegexp(value) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", value, err)
+		}
+		return re, nil
+	case MatchRegex:
+		re, err := regexp.Compile("(?i)" + value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", value, err)
+		}
+		return re, nil
+	default:
+		return nil, fmt.Errorf("unknown match mode: %s", mode)
+	}
+}
+
+// matchValue compares value with ruleValue case-insensitively. re is the
+// pattern compiled by compilePattern for glob and regex modes.
+func matchValue(mode MatchMode, re *regexp.Regexp, value, ruleValue string) bool {
+	switch mode {
+	case MatchExact:
+		return strings.EqualFold(value, ruleValue)
+	case MatchContains:
+		return strings.Contains(strings.ToLower(value), strings.ToLower(ruleValue))
+	case MatchSuffix:
+		return strings.HasSuffix(strings.ToLower(value), strings.ToLower(ruleValue))
+	case MatchGlob, MatchRegex:
+		return re != nil && re.MatchString(value)
+	default:
+		return false
+	}
+}
+
+// or returns m, or def when the rule was built without a mode.
+func (m MatchMode) or(def MatchMode) MatchMode {
+	if m == "" {
+		return def
+	}
+	return m
+}
+
+// globToRegexp translates * and ? wildcards, everything else is literal.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
+
+// parseMatchMode reads the optional "match" field of a rule.
+func parseMatchMode(data map[string]any, defaultMode MatchMode) (MatchMode, error) {
+	raw, exists := data["match"]
+	if !exists {
+		return defaultMode, nil
+	}
+	mode, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("invalid 'match' field")
+	}
+	return MatchMode(mode), nil
+}
+
+// searchText returns a string every value matched by mode contains, for a
+// server side SEARCH. Globs use their longest literal part. Regular
+// expressions can't be searched for.
+func searchText(mode MatchMode, value string) (string, bool) {
+	switch mode {
+	case MatchExact, MatchContains, MatchSuffix:
+		return value, true
+	case MatchGlob:
+		longest := ""
+		for _, part := range strings.FieldsFunc(value, func(r rune) bool { return r == '*' || r == '?' }) {
+			if len(part) > len(longest) {
+				longest = part
+			}
+		}
+		return longest, longest != ""
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,80 @@
+package rule
+
+import "testing"
+
+func TestMatchValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      MatchMode
+		value     string
+		ruleValue string
+		want      bool
+	}{
+		{name: "exact", mode: MatchExact, value: "News@Example.com", ruleValue: "news@example.com", want: true},
+		{name: "exact no match", mode: MatchExact, value: "news@example.com.ua", ruleValue: "news@example.com", want: false},
+		{name: "contains", mode: MatchContains, value: "promo.Shop.com", ruleValue: "shop", want: true},
+		{name: "suffix", mode: MatchSuffix, value: "news@mail.shop.com", ruleValue: "@mail.shop.com", want: true},
+		{name: "suffix no match", mode: MatchSuffix, value: "news@mail.shop.com.ua", ruleValue: "shop.com", want: false},
+		{name: "glob star", mode: MatchGlob, value: "noreply@accounts.google.com", ruleValue: "noreply@*.google.com", want: true},
+		{name: "glob question mark", mode: MatchGlob, value: "news1@shop.com", ruleValue: "news?@shop.com", want: true},
+		{name: "glob is anchored", mode: MatchGlob, value: "noreply@google.com.evil.org", ruleValue: "noreply@*google.com", want: false},
+		{name: "glob dot is literal", mode: MatchGlob, value: "shopXcom", ruleValue: "shop.com", want: false},
+		{name: "regex", mode: MatchRegex, value: "Order #12345 shipped", ruleValue: `order #\d+`, want: true},
+		{name: "regex no match", mode: MatchRegex, value: "Order shipped", ruleValue: `order #\d+`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re, err := compilePattern(tt.mode, tt.ruleValue)
+			if err != nil {
+				t.Fatalf("compilePattern() error = %v", err)
+			}
+			if got := matchValue(tt.mode, re, tt.value, tt.ruleValue); got != tt.want {
+				t.Errorf("matchValue(%s, %q, %q) = %v, want %v", tt.mode, tt.value, tt.ruleValue, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompilePattern_Invalid(t *testing.T) {
+	if _, err := compilePattern(MatchRegex, "news[0-9"); err == nil {
+		t.Errorf("compilePattern() expected error for invalid regex")
+	}
+	if _, err := compilePattern("fuzzy", "news"); err == nil {
+		t.Errorf("compilePattern() expected error for unknown mode")
+	}
+}
+
+func TestCreateFromFile_MatchModes(t *testing.T) {
+	path := writeRulesFile(t, `[
+		{"type": "address_rule", "address": "noreply@*.example.com", "match": "glob"},
+		{"type": "domain_rule", "domain": "^mail\\.", "match": "regex"},
+		{"type": "theme_rule", "text": "digest", "match": "suffix"}
+	]`)
+
+	got, err := CreateFromFile(path)
+	if err != nil {
+		t.Fatalf("CreateFromFile() error = %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("CreateFromFile() returned %d rules, want 3", len(got))
+	}
+
+	if !got[0].ShouldDelete(newTestMessage("noreply", "news.example.com", "")) {
+		t.Errorf("glob address rule did not match")
+	}
+	if !got[1].ShouldDelete(newTestMessage("news", "mail.example.com", "")) {
+		t.Errorf("regex domain rule did not match")
+	}
+	if got[1].ShouldDelete(newTestMessage("news", "gmail.com", "")) {
+		t.Errorf("regex domain rule matched unanchored domain")
+	}
+	if !got[2].ShouldDelete(newTestMessage("news", "example.com", "Your weekly DIGEST")) {
+		t.Errorf("suffix theme rule did not match")
+	}
+
+	assertInvalidRules(t,
+		`{"type": "theme_rule", "text": "[broken", "match": "regex"}`,
+		`{"type": "theme_rule", "text": "sale", "match": "fuzzy"}`,
+	)
+}
@@ -1,6 +1,7 @@
 package rule
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"mail-cleaner/internal/rules"
@@ -15,7 +16,26 @@ func RegisterRuleFactory(ruleType string, factory RuleFactory) {
 	factories[ruleType] = factory
 }
 
+// RulesFile is a parsed rules file. The file is either a list of rules or an
+// object with the rules and the folders they apply to:
+//
+//	{"folders": ["INBOX", "Newsletters/*"], "rules": [...]}
+type RulesFile struct {
+	// Folders are folder names or globs to process, empty when the file
+	// doesn't choose.
+	Folders []string
+	Rules   []rules.Rule
+}
+
 func CreateFromFile(rule_set_file string) ([]rules.Rule, error) {
+	file, err := LoadFile(rule_set_file)
+	if err != nil {
+		return nil, err
+	}
+	return file.Rules, nil
+}
+
+func LoadFile(rule_set_file string) (*RulesFile, error) {
 	// load data from json file and create rules
 	file_data, err := os.ReadFile(rule_set_file)
 	if err != nil {
@@ -23,36 +43,121 @@ func CreateFromFile(rule_set_file string) ([]rules.Rule, error) {
 	}
 
 	var raw_rules []map[string]any
-	if err := json.Unmarshal(file_data, &raw_rules); err != nil {
+	var folders []string
+	if trimmed := bytes.TrimSpace(file_data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var raw_file struct {
+			Folders []string         `json:"folders"`
+			Rules   []map[string]any `json:"rules"`
+		}
+		if err := json.Unmarshal(file_data, &raw_file); err != nil {
+			return nil, fmt.Errorf("failed to parse rules file: %w", err)
+		}
+		raw_rules = raw_file.Rules
+		folders = raw_file.Folders
+	} else if err := json.Unmarshal(file_data, &raw_rules); err != nil {
 		return nil, fmt.Errorf("failed to parse rules file: %w", err)
 	}
 
 	var rulesList []rules.Rule
-	for _, raw_rule := range raw_rules {
-		ruleType, ok := raw_rule["type"].(string)
-		if !ok {
-			fmt.Println("Invalid rule type in rules file")
-			continue
-		}
-
-		factory, exists := factories[ruleType]
-		if !exists {
-			fmt.Printf("No factory registered for rule type: %s\n", ruleType)
-			continue
-		}
-
-		rule, err := factory(raw_rule)
+	for i, raw_rule := range raw_rules {
+		rule, err := createRule(raw_rule)
 		if err != nil {
-			fmt.Printf("Error creating rule of type %s: %v\n", ruleType, err)
-			continue
+			// rules like ai_local_rule hold an open log file
+			closeRules(rulesList)
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
 		}
 
 		rulesList = append(rulesList, rule)
 	}
 
-	if len(rulesList) == 0 {
-		fmt.Println("No valid rules found in the rules file.")
+	return &RulesFile{Folders: folders, Rules: rulesList}, nil
+}
+
+func createRule(raw_rule map[string]any) (rules.Rule, error) {
+	rule, err := newRule(raw_rule)
+	if err != nil {
+		return nil, err
+	}
+
+	rule, err = withAction(rule, raw_rule)
+	if err != nil {
+		return nil, fmt.Errorf("error creating rule of type %s: %w", raw_rule["type"], err)
+	}
+
+	rule, err = withFolders(rule, raw_rule)
+	if err != nil {
+		return nil, fmt.Errorf("error creating rule of type %s: %w", raw_rule["type"], err)
+	}
+
+	return rule, nil
+}
+
+// newRule creates a rule without applying its action, so it can be used as a
+// plain matcher inside composite rules.
+func newRule(raw_rule map[string]any) (rules.Rule, error) {
+	ruleType, ok := raw_rule["type"].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid rule type in rules file")
 	}
 
-	return rulesList, nil
+	factory, exists := factories[ruleType]
+	if !exists {
+		return nil, fmt.Errorf("no factory registered for rule type: %s", ruleType)
+	}
+
+	rule, err := factory(raw_rule)
+	if err != nil {
+		return nil, fmt.Errorf("error creating rule of type %s: %w", ruleType, err)
+	}
+
+	return rule, nil
+}
+
+// withAction applies the optional "action" and "target" fields shared by all
+// rule types. "move_to" is kept as a shorthand for a move action.
+func withAction(rule rules.Rule, raw_rule map[string]any) (rules.Rule, error) {
+	if _, ok := rule.(rules.Decider); ok {
+		// the rule parsed its own action
+		return rule, nil
+	}
+	if _, ok := rule.(rules.Allower); ok {
+		// allow rules always keep the email
+		return rule, nil
+	}
+
+	actionName, _ := raw_rule["action"].(string)
+	target, _ := raw_rule["target"].(string)
+	if folder, ok := raw_rule["move_to"].(string); ok && folder != "" && actionName == "" {
+		actionName = string(rules.ActionMove)
+		target = folder
+	}
+
+	action, err := rules.ParseAction(actionName)
+	if err != nil {
+		return nil, err
+	}
+	if action == rules.ActionDelete {
+		return rule, nil
+	}
+
+	return rules.WithAction(rule, action, target)
+}
+
+// withFolders applies the optional "folders" and "exclude_folders" fields
+// shared by all rule types. The scope is enforced by rules.Rules.
+func withFolders(rule rules.Rule, raw_rule map[string]any) (rules.Rule, error) {
+	folders, err := parseStrings(raw_rule, "folders")
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := parseStrings(raw_rule, "exclude_folders")
+	if err != nil {
+		return nil, err
+	}
+	for _, folder := range append(append([]string{}, folders...), exclude...) {
+		if folder == "" {
+			return nil, fmt.Errorf("folder cannot be empty")
+		}
+	}
+	return rules.WithFolders(rule, rules.FolderScope{Folders: folders, Exclude: exclude}), nil
 }
@@ -0,0 +1,224 @@
+package rule
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mail-cleaner/internal/rules"
+
+	"github.com/emersion/go-imap"
+)
+
+func writeRulesFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write rules file: %v", err)
+	}
+	return path
+}
+
+// assertInvalidRules checks that loading each of rawRules alone fails.
+func assertInvalidRules(t *testing.T, rawRules ...string) {
+	t.Helper()
+	for _, raw := range rawRules {
+		if _, err := CreateFromFile(writeRulesFile(t, "["+raw+"]")); err == nil {
+			t.Errorf("CreateFromFile(%s) expected error", raw)
+		}
+	}
+}
+
+func TestCreateFromFile_Actions(t *testing.T) {
+	path := writeRulesFile(t, `[
+		{"type": "domain_rule", "domain": "promo.com", "move_to": "Trash"},
+		{"type": "theme_rule", "text": "invoice", "action": "flag"},
+		{"type": "theme_rule", "text": "digest", "action": "label", "target": "newsletters"},
+		{"type": "address_rule", "address": "spam@example.com"}
+	]`)
+
+	got, err := CreateFromFile(path)
+	if err != nil {
+		t.Fatalf("CreateFromFile() error = %v", err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("CreateFromFile() returned %d rules, want 4", len(got))
+	}
+
+	msg := &imap.Message{
+		Envelope: &imap.Envelope{
+			From:    []*imap.Address{{MailboxName: "spam", HostName: "promo.com"}},
+			Subject: "Weekly digest and invoice",
+		},
+	}
+
+	tests := []struct {
+		name       string
+		rule       rules.Rule
+		wantAction rules.Action
+		wantTarget string
+	}{
+		{name: "move_to shorthand", rule: got[0], wantAction: rules.ActionMove, wantTarget: "Trash"},
+		{name: "flag action", rule: got[1], wantAction: rules.ActionFlag},
+		{name: "label with target", rule: got[2], wantAction: rules.ActionLabel, wantTarget: "newsletters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decider, ok := tt.rule.(rules.Decider)
+			if !ok {
+				t.Fatalf("rule with action does not implement rules.Decider")
+			}
+			decision, matched := decider.Decide(msg)
+			if !matched {
+				t.Fatalf("Decide() did not match")
+			}
+			if decision.Action != tt.wantAction || decision.Target != tt.wantTarget {
+				t.Errorf("Decide() = %s %q, want %s %q", decision.Action, decision.Target, tt.wantAction, tt.wantTarget)
+			}
+			if tt.rule.ShouldDelete(msg) {
+				t.Errorf("ShouldDelete() = true for a %s rule", tt.wantAction)
+			}
+		})
+	}
+
+	if _, ok := got[3].(rules.Decider); ok {
+		t.Errorf("rule without action should stay a plain delete rule")
+	}
+
+	decision, ok := rules.NewRules(got[1:]).Decide(msg)
+	if !ok || decision.Action != rules.ActionFlag {
+		t.Errorf("Rules.Decide() = %v, %v, want first matching rule's flag action", decision, ok)
+	}
+
+	assertInvalidRules(t,
+		`{"type": "address_rule", "address": "bad@example.com", "action": "explode"}`,
+		`{"type": "address_rule", "address": "news@example.com", "action": "move"}`,
+	)
+}
+
+func TestCreateFromFile_InvalidRule(t *testing.T) {
+	// a typo in an allow rule must not silently drop the protection
+	path := writeRulesFile(t, `[
+		{"type": "domain_rule", "domain": "promo.com"},
+		{"type": "allow_address", "address": "(boss@promo.com", "match": "regex"}
+	]`)
+
+	got, err := CreateFromFile(path)
+	if err == nil {
+		t.Fatalf("CreateFromFile() = %d rules, want an error for the bad regex", len(got))
+	}
+	if !strings.Contains(err.Error(), "rule 2") {
+		t.Errorf("CreateFromFile() error = %q, want it to name rule 2", err)
+	}
+
+	assertInvalidRules(t, `{"type": "unknown_rule"}`)
+}
+
+// closingRule records whether it was closed.
+type closingRule struct {
+	closed bool
+}
+
+func (r *closingRule) ShouldDelete(msg *imap.Message) bool {
+	return false
+}
+
+func (r *closingRule) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestLoadFile_ClosesRulesOnError(t *testing.T) {
+	loaded := &closingRule{}
+	RegisterRuleFactory("closing_rule", func(map[string]any) (rules.Rule, error) {
+		return loaded, nil
+	})
+	t.Cleanup(func() { delete(factories, "closing_rule") })
+
+	path := writeRulesFile(t, `[
+		{"type": "closing_rule", "action": "flag"},
+		{"type": "unknown_rule"}
+	]`)
+	if _, err := LoadFile(path); err == nil {
+		t.Fatalf("LoadFile() expected error")
+	}
+	if !loaded.closed {
+		t.Errorf("LoadFile() left the rules loaded before the error open")
+	}
+}
+
+func TestLoadFile_Folders(t *testing.T) {
+	path := writeRulesFile(t, `{
+		"folders": ["INBOX", "Newsletters/*"],
+		"rules": [
+			{"type": "domain_rule", "domain": "promo.com"}
+		]
+	}`)
+
+	got, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if len(got.Folders) != 2 || got.Folders[1] != "Newsletters/*" {
+		t.Errorf("LoadFile() folders = %v", got.Folders)
+	}
+	if len(got.Rules) != 1 {
+		t.Errorf("LoadFile() returned %d rules, want 1", len(got.Rules))
+	}
+
+	list, err := LoadFile(writeRulesFile(t, `[{"type": "domain_rule", "domain": "promo.com"}]`))
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if len(list.Folders) != 0 || len(list.Rules) != 1 {
+		t.Errorf("LoadFile() of a rules list = %+v", list)
+	}
+}
+
+func TestCreateFromFile_FolderScope(t *testing.T) {
+	path := writeRulesFile(t, `[
+		{"type": "domain_rule", "domain": "promo.com", "folders": "INBOX"},
+		{"type": "theme_rule", "text": "sale", "exclude_folders": ["Archive", "Archive/*"]},
+		{"type": "allow_address", "address": "boss@promo.com", "folders": ["Newsletters/*"]}
+	]`)
+
+	got, err := CreateFromFile(path)
+	if err != nil {
+		t.Fatalf("CreateFromFile() error = %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("CreateFromFile() returned %d rules, want 3", len(got))
+	}
+	set := rules.NewRules(got)
+
+	tests := []struct {
+		name   string
+		folder string
+		msg    *imap.Message
+		want   bool
+	}{
+		{name: "promo in inbox", folder: "inbox", msg: newTestMessage("news", "promo.com", "Hello"), want: true},
+		{name: "promo in archive", folder: "Archive", msg: newTestMessage("news", "promo.com", "Hello"), want: false},
+		{name: "sale in newsletters", folder: "Newsletters", msg: newTestMessage("news", "shop.com", "Big sale"), want: true},
+		{name: "sale in archive subfolder", folder: "Archive/2023", msg: newTestMessage("news", "shop.com", "Big sale"), want: false},
+		{name: "boss allowed in newsletters", folder: "Newsletters/Promo", msg: newTestMessage("boss", "promo.com", "Sale"), want: false},
+		{name: "boss not allowed in inbox", folder: "INBOX", msg: newTestMessage("boss", "promo.com", "Sale"), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := set.InFolder(tt.folder).ShouldDelete(tt.msg); got != tt.want {
+				t.Errorf("InFolder(%q).ShouldDelete() = %v, want %v", tt.folder, got, tt.want)
+			}
+		})
+	}
+
+	// without a folder only unrestricted rules apply
+	if set.ShouldDelete(newTestMessage("news", "promo.com", "Hello")) {
+		t.Errorf("rule limited to INBOX applied outside any folder")
+	}
+
+	assertInvalidRules(t, `{"type": "domain_rule", "domain": "spam.com", "folders": [""]}`)
+}
@@ -0,0 +1,226 @@
+package rule
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"mail-cleaner/internal/rules"
+)
+
+func TestRules_SearchCriteria(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  string
+		want   string
+		wantOk bool
+	}{
+		{
+			name:   "address",
+			rules:  `[{"type": "address_rule", "address": "spam@example.com"}]`,
+			want:   "[FROM spam@example.com]",
+			wantOk: true,
+		},
+		{
+			name:   "domain in recipients",
+			rules:  `[{"type": "domain_rule", "domain": "lists.org", "field": ["to", "cc"]}]`,
+			want:   "[OR [TO lists.org] [CC lists.org]]",
+			wantOk: true,
+		},
+		{
+			name:   "registrable domain",
+			rules:  `[{"type": "domain_rule", "domain": "news.example.co.uk", "match": "registrable"}]`,
+			want:   "[FROM example.co.uk]",
+			wantOk: true,
+		},
+		{
+			name:   "glob uses longest literal",
+			rules:  `[{"type": "theme_rule", "text": "order * shipped", "match": "glob"}]`,
+			want:   "[SUBJECT  shipped]",
+			wantOk: true,
+		},
+		{
+			name:   "header exists",
+			rules:  `[{"type": "header_rule", "header": "list-unsubscribe"}]`,
+			want:   "[HEADER List-Unsubscribe ]",
+			wantOk: true,
+		},
+		{
+			name:   "size and flags",
+			rules:  `[{"type": "all_of", "rules": [{"type": "size_rule", "larger_than": 1000}, {"type": "flags_rule", "has": "seen", "lacks": "flagged"}]}]`,
+			want:   "[SEEN UNFLAGGED LARGER 1000]",
+			wantOk: true,
+		},
+		{
+			name:   "all_of skips what can't be searched",
+			rules:  `[{"type": "all_of", "rules": [{"type": "theme_rule", "text": "^x$", "match": "regex"}, {"type": "theme_rule", "text": "sale"}]}]`,
+			want:   "[SUBJECT sale]",
+			wantOk: true,
+		},
+		{
+			name:   "rules are or-ed, actions kept",
+			rules:  `[{"type": "address_rule", "address": "a@x.com"}, {"type": "theme_rule", "text": "b", "action": "flag"}, {"type": "size_rule", "smaller_than": 10}]`,
+			want:   "[OR [FROM a@x.com] [OR [SUBJECT b] [SMALLER 10]]]",
+			wantOk: true,
+		},
+		{
+			name:   "allow rules don't widen the search",
+			rules:  `[{"type": "address_rule", "address": "a@x.com"}, {"type": "allow_domain", "domain": "x.com"}]`,
+			want:   "[FROM a@x.com]",
+			wantOk: true,
+		},
+		{
+			name:  "regex",
+			rules: `[{"type": "address_rule", "address": "a@x.com"}, {"type": "theme_rule", "text": "^x$", "match": "regex"}]`,
+		},
+		{
+			name:  "any_of with an unsearchable child",
+			rules: `[{"type": "any_of", "rules": [{"type": "address_rule", "address": "a@x.com"}, {"type": "body_rule", "text": "x"}]}]`,
+		},
+		{
+			name:  "not",
+			rules: `[{"type": "not", "rule": {"type": "address_rule", "address": "a@x.com"}}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := CreateFromFile(writeRulesFile(t, tt.rules))
+			if err != nil {
+				t.Fatalf("CreateFromFile() error = %v", err)
+			}
+			criteria, ok := rules.NewRules(list).SearchCriteria()
+			if ok != tt.wantOk {
+				t.Fatalf("SearchCriteria() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if !ok {
+				return
+			}
+			if got := fmt.Sprint(criteria.Format()); got != tt.want {
+				t.Errorf("SearchCriteria() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDateRule_SearchCriteria(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	rule, err := NewDateRule(&DateRule{OlderThan: 30 * day, now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatalf("NewDateRule() error = %v", err)
+	}
+	criteria, ok := rule.SearchCriteria()
+	if !ok {
+		t.Fatalf("SearchCriteria() ok = false")
+	}
+	// 30 days before is 16 May, widened to be safe across time zones
+	if want := time.Date(2024, 5, 18, 12, 0, 0, 0, time.UTC); !criteria.Before.Equal(want) || !criteria.Since.IsZero() {
+		t.Errorf("SearchCriteria() since %v before %v, want before %v", criteria.Since, criteria.Before, want)
+	}
+
+	rule, _ = NewDateRule(&DateRule{
+		After:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Before: time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+		Source: DateHeader,
+	})
+	criteria, _ = rule.SearchCriteria()
+	wantSince := time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)
+	wantBefore := time.Date(2024, 7, 3, 0, 0, 0, 0, time.UTC)
+	if !criteria.SentSince.Equal(wantSince) || !criteria.SentBefore.Equal(wantBefore) || !criteria.Before.IsZero() {
+		t.Errorf("SearchCriteria() sent since %v before %v, want %v and %v",
+			criteria.SentSince, criteria.SentBefore, wantSince, wantBefore)
+	}
+}
+
+func TestRules_AgedCriteria(t *testing.T) {
+	now := func() time.Time { return time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC) }
+	olderThan, err := NewDateRule(&DateRule{OlderThan: 30 * day, Source: DateHeader, now: now})
+	if err != nil {
+		t.Fatalf("NewDateRule() error = %v", err)
+	}
+	newerThan, _ := NewDateRule(&DateRule{NewerThan: 7 * day, now: now})
+	between, _ := NewDateRule(&DateRule{Before: time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)})
+	domain, _ := NewDomainRule("x.com")
+	body, _ := NewBodyRule("x", MatchContains)
+	allOf, _ := NewAllOfRule([]rules.Rule{domain, olderThan})
+	anyOf, _ := NewAnyOfRule([]rules.Rule{body, olderThan})
+
+	lastRun := time.Date(2024, 7, 25, 0, 0, 0, 0, time.UTC)
+	// 30 days before 1 August, widened like SearchCriteria
+	wantBefore := time.Date(2024, 7, 4, 0, 0, 0, 0, time.UTC)
+	// 30 days before the last run, widened by a day
+	wantSince := time.Date(2024, 6, 24, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		rules     []rules.Rule
+		lastRun   time.Time
+		want      string
+		wantSince time.Time
+		wantOk    bool
+	}{
+		{
+			name:  "no date rules",
+			rules: []rules.Rule{domain, body},
+		},
+		{
+			name:  "newer_than doesn't age",
+			rules: []rules.Rule{newerThan},
+		},
+		{
+			name:  "date_between doesn't age",
+			rules: []rules.Rule{between},
+		},
+		{
+			name:   "first run checks every old email",
+			rules:  []rules.Rule{olderThan},
+			want:   "[ALL]",
+			wantOk: true,
+		},
+		{
+			name:      "only emails that got old since the last run",
+			rules:     []rules.Rule{olderThan},
+			lastRun:   lastRun,
+			want:      "[ALL]",
+			wantSince: wantSince,
+			wantOk:    true,
+		},
+		{
+			name:      "all_of keeps the searchable children",
+			rules:     []rules.Rule{allOf},
+			lastRun:   lastRun,
+			want:      "[FROM x.com]",
+			wantSince: wantSince,
+			wantOk:    true,
+		},
+		{
+			name:      "any_of only uses the aging children",
+			rules:     []rules.Rule{domain, anyOf},
+			lastRun:   lastRun,
+			want:      "[ALL]",
+			wantSince: wantSince,
+			wantOk:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			criteria, ok := rules.NewRules(tt.rules).AgedCriteria(tt.lastRun)
+			if ok != tt.wantOk {
+				t.Fatalf("AgedCriteria() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if !ok {
+				return
+			}
+			// dates are compared apart, Format keeps them as time.Time
+			if !criteria.SentSince.Equal(tt.wantSince) || !criteria.SentBefore.Equal(wantBefore) {
+				t.Errorf("AgedCriteria() sent since %v before %v, want %v and %v",
+					criteria.SentSince, criteria.SentBefore, tt.wantSince, wantBefore)
+			}
+			criteria.SentSince, criteria.SentBefore = time.Time{}, time.Time{}
+			if got := fmt.Sprint(criteria.Format()); got != tt.want {
+				t.Errorf("AgedCriteria() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"mail-cleaner/internal/rules"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// SizeRule matches emails by their RFC822.SIZE in bytes. A zero bound is
+// not checked.
+type SizeRule struct {
+	LargerThan  uint32
+	SmallerThan uint32
+}
+
+func init() {
+	RegisterRuleFactory("size_rule", func(data map[string]any) (rules.Rule, error) {
+		larger, err := parseSize(data, "larger_than")
+		if err != nil {
+			return nil, err
+		}
+		smaller, err := parseSize(data, "smaller_than")
+		if err != nil {
+			return nil, err
+		}
+		return NewSizeRule(larger, smaller)
+	})
+}
+
+func NewSizeRule(largerThan, smallerThan uint32) (*SizeRule, error) {
+	if largerThan == 0 && smallerThan == 0 {
+		return nil, errors.New("size rule needs 'larger_than' or 'smaller_than'")
+	}
+	if smallerThan != 0 && largerThan >= smallerThan {
+		return nil, errors.New("'larger_than' must be less than 'smaller_than'")
+	}
+	return &SizeRule{
+		LargerThan:  largerThan,
+		SmallerThan: smallerThan,
+	}, nil
+}
+
+func (r *SizeRule) ShouldDelete(msg *imap.Message) bool {
+	if msg.Size == 0 {
+		// size was not fetched
+		return false
+	}
+	if r.LargerThan != 0 && msg.Size <= r.LargerThan {
+		return false
+	}
+	if r.SmallerThan != 0 && msg.Size >= r.SmallerThan {
+		return false
+	}
+	return true
+}
+
+func (r *SizeRule) String() string {
+	return fmt.Sprintf("SizeRule{LargerThan: %d, SmallerThan: %d}", r.LargerThan, r.SmallerThan)
+}
+
+var sizeUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"B", 1},
+}
+
+// parseSize reads an optional size field given in bytes or as a string with
+// a unit, e.g. 1048576, "500KB" or "5MB".
+func parseSize(data map[string]any, field string) (uint32, error) {
+	raw, exists := data[field]
+	if !exists {
+		return 0, nil
+	}
+
+	var size float64
+	switch value := raw.(type) {
+	case float64:
+		size = value
+	case string:
+		text := strings.ToUpper(strings.TrimSpace(value))
+		multiplier := 1.0
+		for _, unit := range sizeUnits {
+			if strings.HasSuffix(text, unit.suffix) {
+				text = strings.TrimSpace(strings.TrimSuffix(text, unit.suffix))
+				multiplier = unit.multiplier
+				break
+			}
+		}
+		number, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid '%s' size %q", field, value)
+		}
+		size = number * multiplier
+	default:
+		return 0, fmt.Errorf("invalid '%s' field", field)
+	}
+
+	if size <= 0 || size > float64(^uint32(0)) {
+		return 0, fmt.Errorf("'%s' is out of range", field)
+	}
+	return uint32(size), nil
+}
+
+func (r *SizeRule) SearchCriteria() (*imap.SearchCriteria, bool) {
+	return &imap.SearchCriteria{Larger: r.LargerThan, Smaller: r.SmallerThan}, true
+}
@@ -0,0 +1,61 @@
+package rule
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestSizeRule_ShouldDelete(t *testing.T) {
+	tests := []struct {
+		name string
+		rule *SizeRule
+		size uint32
+		want bool
+	}{
+		{name: "larger than", rule: &SizeRule{LargerThan: 1000}, size: 1001, want: true},
+		{name: "not larger than", rule: &SizeRule{LargerThan: 1000}, size: 1000, want: false},
+		{name: "smaller than", rule: &SizeRule{SmallerThan: 1000}, size: 999, want: true},
+		{name: "not smaller than", rule: &SizeRule{SmallerThan: 1000}, size: 1000, want: false},
+		{name: "inside range", rule: &SizeRule{LargerThan: 100, SmallerThan: 1000}, size: 500, want: true},
+		{name: "size not fetched", rule: &SizeRule{SmallerThan: 1000}, size: 0, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.ShouldDelete(&imap.Message{Size: tt.size}); got != tt.want {
+				t.Errorf("SizeRule.ShouldDelete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   any
+		want    uint32
+		wantErr bool
+	}{
+		{name: "bytes number", value: float64(2048), want: 2048},
+		{name: "kilobytes", value: "500KB", want: 500 << 10},
+		{name: "megabytes lowercase", value: "5mb", want: 5 << 20},
+		{name: "fractional", value: "1.5 M", want: 3 << 19},
+		{name: "plain bytes string", value: "100", want: 100},
+		{name: "invalid unit", value: "5 parsecs", wantErr: true},
+		{name: "negative", value: float64(-1), wantErr: true},
+		{name: "too large", value: "8GB", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSize(map[string]any{"larger_than": tt.value}, "larger_than")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,13 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"mail-cleaner/internal/rules"
-	"strings"
+	"regexp"
 
 	"github.com/emersion/go-imap"
 )
 
 type ThemeRule struct {
-	Text string
+	Text  string
+	Match MatchMode
+	re    *regexp.Regexp
 }
 
 func init() {
@@ -19,33 +21,59 @@ func init() {
 		if !ok {
 			return nil, fmt.Errorf("invalid or missing 'text' field")
 		}
-		return NewThemeRule(text)
+		mode, err := parseMatchMode(data, MatchContains)
+		if err != nil {
+			return nil, err
+		}
+		return NewThemeRuleWithMatch(text, mode)
 	})
 }
 
 func NewThemeRule(text string) (*ThemeRule, error) {
+	return NewThemeRuleWithMatch(text, MatchContains)
+}
+
+func NewThemeRuleWithMatch(text string, mode MatchMode) (*ThemeRule, error) {
 	if text == "" {
 		return nil, errors.New("text cannot be empty")
 	}
+	re, err := compilePattern(mode, text)
+	if err != nil {
+		return nil, err
+	}
 	return &ThemeRule{
-		Text: text,
+		Text:  text,
+		Match: mode,
+		re:    re,
 	}, nil
 }
 
 func (r *ThemeRule) ShouldDelete(msg *imap.Message) bool {
+	return r.Matches(msg)
+}
+
+// Matches reports whether the email subject matches the rule's text.
+func (r *ThemeRule) Matches(msg *imap.Message) bool {
 	if msg.Envelope == nil {
 		return false
 	}
 
-	if msg.Envelope.Subject != "" && containsIgnoreCase(msg.Envelope.Subject, r.Text) {
-		fmt.Printf("Deleting email with subject containing: %s\n", r.Text)
-		return true
+	return msg.Envelope.Subject != "" && matchValue(r.Match.or(MatchContains), r.re, msg.Envelope.Subject, r.Text)
+}
+
+func (r *ThemeRule) String() string {
+	if r.Match.or(MatchContains) != MatchContains {
+		return fmt.Sprintf("ThemeRule{Text: %s, Match: %s}", r.Text, r.Match)
 	}
-	return false
+	return fmt.Sprintf("ThemeRule{Text: %s}", r.Text)
 }
 
-func containsIgnoreCase(s1, s2 string) bool {
-	s1Lower := strings.ToLower(s1)
-	s2Lower := strings.ToLower(s2)
-	return strings.Contains(s1Lower, s2Lower)
+func (r *ThemeRule) SearchCriteria() (*imap.SearchCriteria, bool) {
+	text, ok := searchText(r.Match.or(MatchContains), r.Text)
+	if !ok {
+		return nil, false
+	}
+	criteria := imap.NewSearchCriteria()
+	criteria.Header.Add("Subject", text)
+	return criteria, true
 }
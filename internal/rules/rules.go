@@ -1,6 +1,8 @@
 package rules
 
 import (
+	"io"
+
 	"github.com/emersion/go-imap"
 )
 
@@ -8,19 +10,100 @@ type Rule interface {
 	ShouldDelete(msg *imap.Message) bool
 }
 
+// Allower is implemented by allow-list rules. An email allowed by any of
+// them is kept, whatever rules come before or after it in the file.
+type Allower interface {
+	Allows(msg *imap.Message) bool
+}
+
 type Rules struct {
+	allow []Rule
 	rules []Rule
+	// entries are all rules in file order with their folder scopes.
+	entries []scopedRule
 }
 
+// NewRules creates a rule set. Rules limited to some folders with
+// WithFolders are left out until InFolder selects a folder they apply in.
 func NewRules(rules []Rule) *Rules {
-	return &Rules{rules: rules}
+	r := &Rules{}
+	for _, rule := range rules {
+		if scoped, ok := rule.(*scopedRule); ok {
+			r.entries = append(r.entries, *scoped)
+		} else {
+			r.entries = append(r.entries, scopedRule{Rule: rule})
+		}
+	}
+	r.build("")
+	return r
+}
+
+func (r *Rules) build(folder string) {
+	r.allow, r.rules = nil, nil
+	for _, entry := range r.entries {
+		if !entry.scope.Includes(folder) {
+			continue
+		}
+		if _, ok := entry.Rule.(Allower); ok {
+			r.allow = append(r.allow, entry.Rule)
+		} else {
+			r.rules = append(r.rules, entry.Rule)
+		}
+	}
+}
+
+// OutputSetter is implemented by rules that print while they match, like
+// ai_local_rule.
+type OutputSetter interface {
+	SetOutput(w io.Writer)
+}
+
+// SetOutput sends what the rules in the set print to w, e.g. the output of
+// one account.
+func (r *Rules) SetOutput(w io.Writer) {
+	for _, entry := range r.entries {
+		if setter, ok := entry.Rule.(OutputSetter); ok {
+			setter.SetOutput(w)
+		}
+	}
 }
 
 func (r *Rules) ShouldDelete(msg *imap.Message) bool {
+	decision, ok := r.Decide(msg)
+	return ok && decision.Action == ActionDelete
+}
+
+// Decide returns the decision of the first rule matching the message. Rules
+// that don't implement Decider are treated as delete rules. Allow rules are
+// checked first and veto every other rule.
+func (r *Rules) Decide(msg *imap.Message) (Decision, bool) {
+	if decision, ok := r.allowed(msg); ok {
+		return decision, true
+	}
+
 	for _, rule := range r.rules {
-		if rule.ShouldDelete(msg) {
-			return true
+		if decision, ok := decide(rule, msg); ok {
+			return decision, true
+		}
+	}
+	return Decision{}, false
+}
+
+func (r *Rules) allowed(msg *imap.Message) (Decision, bool) {
+	for _, rule := range r.allow {
+		if rule.(Allower).Allows(msg) {
+			return Decision{Action: ActionKeep, Reason: Describe(rule)}, true
 		}
 	}
-	return false
+	return Decision{}, false
+}
+
+func decide(rule Rule, msg *imap.Message) (Decision, bool) {
+	if decider, ok := rule.(Decider); ok {
+		return decider.Decide(msg)
+	}
+	if rule.ShouldDelete(msg) {
+		return Decision{Action: ActionDelete, Reason: Describe(rule)}, true
+	}
+	return Decision{}, false
 }
@@ -0,0 +1,113 @@
+package rules
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// FolderScope limits a rule to some folders. An empty scope applies
+// everywhere.
+type FolderScope struct {
+	// Folders the rule applies in, all folders when empty.
+	Folders []string
+	// Exclude are folders the rule never applies in, even when listed in
+	// Folders.
+	Exclude []string
+}
+
+// Includes reports whether a rule with this scope applies in folder.
+func (s FolderScope) Includes(folder string) bool {
+	if len(s.Folders) > 0 && !matchAnyFolder(s.Folders, folder) {
+		return false
+	}
+	return !matchAnyFolder(s.Exclude, folder)
+}
+
+func (s FolderScope) IsZero() bool {
+	return len(s.Folders) == 0 && len(s.Exclude) == 0
+}
+
+func matchAnyFolder(patterns []string, folder string) bool {
+	for _, pattern := range patterns {
+		if MatchFolder(pattern, folder) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchFolder reports whether folder matches pattern, a folder name or a glob
+// where * and ? also match the hierarchy delimiter. INBOX is case-insensitive
+// (RFC 3501), other names are not.
+func MatchFolder(pattern, folder string) bool {
+	if strings.EqualFold(pattern, "INBOX") || strings.EqualFold(folder, "INBOX") {
+		return strings.EqualFold(pattern, folder)
+	}
+	if !strings.ContainsAny(pattern, "*?") {
+		return pattern == folder
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	matched, _ := regexp.MatchString(b.String(), folder)
+	return matched
+}
+
+// scopedRule carries the folder scope of a rule until NewRules takes it
+// apart. The scope is enforced by Rules, not by the rule itself.
+type scopedRule struct {
+	Rule
+	scope FolderScope
+}
+
+// WithFolders limits rule to the folders in scope. The returned rule must be
+// used through Rules, see Rules.InFolder.
+func WithFolders(rule Rule, scope FolderScope) Rule {
+	if scope.IsZero() {
+		return rule
+	}
+	return &scopedRule{Rule: rule, scope: scope}
+}
+
+func (s *scopedRule) Needs() Needs {
+	return NeedsOf(s.Rule)
+}
+
+func (s *scopedRule) Close() error {
+	if closer, ok := s.Rule.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (s *scopedRule) String() string {
+	description := Describe(s.Rule)
+	if len(s.scope.Folders) > 0 {
+		description += fmt.Sprintf(" in %s", strings.Join(s.scope.Folders, ", "))
+	}
+	if len(s.scope.Exclude) > 0 {
+		description += fmt.Sprintf(" except %s", strings.Join(s.scope.Exclude, ", "))
+	}
+	return description
+}
+
+// InFolder returns the rules that apply in folder. Rules limited with
+// WithFolders are left out of other folders before they see any email.
+func (r *Rules) InFolder(folder string) *Rules {
+	scoped := &Rules{entries: r.entries}
+	scoped.build(folder)
+	return scoped
+}
@@ -0,0 +1,133 @@
+package rules
+
+import (
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// Searcher is implemented by rules that can be expressed as IMAP SEARCH
+// criteria. The criteria must match every email the rule matches, and may
+// match more: the server search only narrows down what is fetched, the rule
+// still decides. ok is false when the rule has no useful criteria; criteria
+// returned with ok must not be empty.
+type Searcher interface {
+	SearchCriteria() (criteria *imap.SearchCriteria, ok bool)
+}
+
+// SearchOf returns the search criteria of rule, if it has any.
+func SearchOf(rule Rule) (*imap.SearchCriteria, bool) {
+	if searcher, ok := rule.(Searcher); ok {
+		return searcher.SearchCriteria()
+	}
+	return nil, false
+}
+
+// Ager is implemented by rules that may start matching an email only because
+// time passed, like older_than, so emails kept by earlier runs have to be
+// checked again. AgedCriteria must match every email the rule may have
+// started matching since lastRun, or since ever when lastRun is zero; ok is
+// false when the rule doesn't age.
+type Ager interface {
+	AgedCriteria(lastRun time.Time) (criteria *imap.SearchCriteria, ok bool)
+}
+
+// AgedOf returns the aged criteria of rule, if it ages.
+func AgedOf(rule Rule, lastRun time.Time) (*imap.SearchCriteria, bool) {
+	if ager, ok := rule.(Ager); ok {
+		return ager.AgedCriteria(lastRun)
+	}
+	return nil, false
+}
+
+// AgedCriteria returns criteria matching every email a rule in the set may
+// have started matching since lastRun. ok is false when no rule ages.
+func (r *Rules) AgedCriteria(lastRun time.Time) (*imap.SearchCriteria, bool) {
+	var all []*imap.SearchCriteria
+	for _, rule := range r.rules {
+		if criteria, ok := AgedOf(rule, lastRun); ok {
+			all = append(all, criteria)
+		}
+	}
+	if len(all) == 0 {
+		return nil, false
+	}
+	return OrCriteria(all...), true
+}
+
+// SearchCriteria returns criteria matching every email any rule in the set
+// could act on. ok is false when a rule can't be expressed as a search, in
+// which case every email has to be fetched. Allow rules only keep emails, so
+// they never widen the search.
+func (r *Rules) SearchCriteria() (*imap.SearchCriteria, bool) {
+	if len(r.rules) == 0 {
+		return nil, false
+	}
+	var all []*imap.SearchCriteria
+	for _, rule := range r.rules {
+		criteria, ok := SearchOf(rule)
+		if !ok {
+			return nil, false
+		}
+		all = append(all, criteria)
+	}
+	return OrCriteria(all...), true
+}
+
+// AndCriteria combines criteria that must all match. Sequence and UID sets
+// are not used by rules and are not combined.
+func AndCriteria(list ...*imap.SearchCriteria) *imap.SearchCriteria {
+	and := imap.NewSearchCriteria()
+	for _, c := range list {
+		and.Since = later(and.Since, c.Since)
+		and.SentSince = later(and.SentSince, c.SentSince)
+		and.Before = earlier(and.Before, c.Before)
+		and.SentBefore = earlier(and.SentBefore, c.SentBefore)
+		for key, values := range c.Header {
+			for _, value := range values {
+				and.Header.Add(key, value)
+			}
+		}
+		and.Body = append(and.Body, c.Body...)
+		and.Text = append(and.Text, c.Text...)
+		and.WithFlags = append(and.WithFlags, c.WithFlags...)
+		and.WithoutFlags = append(and.WithoutFlags, c.WithoutFlags...)
+		if c.Larger > and.Larger {
+			and.Larger = c.Larger
+		}
+		if c.Smaller != 0 && (and.Smaller == 0 || c.Smaller < and.Smaller) {
+			and.Smaller = c.Smaller
+		}
+		and.Not = append(and.Not, c.Not...)
+		and.Or = append(and.Or, c.Or...)
+	}
+	return and
+}
+
+// OrCriteria combines criteria of which at least one must match.
+func OrCriteria(list ...*imap.SearchCriteria) *imap.SearchCriteria {
+	switch len(list) {
+	case 0:
+		return imap.NewSearchCriteria()
+	case 1:
+		return list[0]
+	}
+	half := len(list) / 2
+	or := imap.NewSearchCriteria()
+	or.Or = [][2]*imap.SearchCriteria{{OrCriteria(list[:half]...), OrCriteria(list[half:]...)}}
+	return or
+}
+
+func later(a, b time.Time) time.Time {
+	if a.IsZero() || b.After(a) {
+		return b
+	}
+	return a
+}
+
+func earlier(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,119 @@
+// Package state remembers how far each folder has been processed, so later
+// runs only look at new emails.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Folder is the saved state of one folder.
+type Folder struct {
+	// UIDValidity of the folder when LastUID was recorded. UIDs from another
+	// UIDVALIDITY mean nothing.
+	UIDValidity uint32 `json:"uid_validity"`
+	// LastUID is the highest UID already processed.
+	LastUID uint32 `json:"last_uid"`
+	// HighestModSeq is the CONDSTORE HIGHESTMODSEQ of the folder when it was
+	// processed, zero when the server doesn't support CONDSTORE.
+	HighestModSeq uint64 `json:"highest_modseq,omitempty"`
+	// LastRun is when the folder was last processed. Emails that got old
+	// enough for an age rule since then are checked again.
+	LastRun time.Time `json:"last_run,omitzero"`
+}
+
+// Store is a JSON file with the state of every account and folder.
+type Store struct {
+	path string
+
+	mu       sync.Mutex
+	Accounts map[string]map[string]Folder `json:"accounts"`
+}
+
+// New returns an empty store that is only kept in memory.
+func New() *Store {
+	return &Store{Accounts: make(map[string]map[string]Folder)}
+}
+
+// Load reads the store at path. A missing file is an empty store.
+func Load(path string) (*Store, error) {
+	s := New()
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	if s.Accounts == nil {
+		s.Accounts = make(map[string]map[string]Folder)
+	}
+	return s, nil
+}
+
+// Account returns the key of an account in the store.
+func Account(email, server string) string {
+	return email + " on " + server
+}
+
+// Get returns the saved state of folder, zero when there is none.
+func (s *Store) Get(account, folder string) Folder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Accounts[account][folder]
+}
+
+// Set records the state of folder. Call Save to write it.
+func (s *Store) Set(account, folder string, state Folder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Accounts[account] == nil {
+		s.Accounts[account] = make(map[string]Folder)
+	}
+	s.Accounts[account][folder] = state
+}
+
+// Save writes the store. The file is replaced atomically, so an interrupted
+// run never leaves a broken state file behind. Stores created with New are
+// not written anywhere. Saves of a shared store don't overlap, so an older
+// snapshot never replaces a newer one.
+func (s *Store) Save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
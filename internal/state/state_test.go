@@ -0,0 +1,88 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestStore_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	store, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() of a missing file error = %v", err)
+	}
+	account := Account("me@example.com", "imap.example.com")
+	if got := store.Get(account, "INBOX"); got != (Folder{}) {
+		t.Errorf("Get() on an empty store = %+v", got)
+	}
+
+	store.Set(account, "INBOX", Folder{UIDValidity: 42, LastUID: 1000})
+	store.Set(account, "Newsletters/Tech", Folder{UIDValidity: 7, LastUID: 5})
+	if err := store.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := loaded.Get(account, "INBOX"); got != (Folder{UIDValidity: 42, LastUID: 1000}) {
+		t.Errorf("Get(INBOX) = %+v", got)
+	}
+	if got := loaded.Get(account, "Newsletters/Tech"); got.LastUID != 5 {
+		t.Errorf("Get(Newsletters/Tech) = %+v", got)
+	}
+	if got := loaded.Get(Account("other@example.com", "imap.example.com"), "INBOX"); got != (Folder{}) {
+		t.Errorf("Get() of another account = %+v", got)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Save() left %d files behind, want only the state file", len(entries))
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Errorf("Load() expected error for a broken file")
+	}
+}
+
+func TestStore_SharedSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// accounts processed in parallel share the store of their state file
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			account := Account(fmt.Sprintf("user%d@example.com", i), "imap.example.com")
+			store.Set(account, "INBOX", Folder{UIDValidity: 1, LastUID: uint32(i + 1)})
+			if err := store.Save(); err != nil {
+				t.Errorf("Save() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(loaded.Accounts) != 10 {
+		t.Errorf("state file has %d accounts, want 10", len(loaded.Accounts))
+	}
+}